@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCancellationParty = errors.New("cancellation must be by RIDER, DRIVER or SYSTEM")
+	ErrRideInProgress           = errors.New("the rider can't cancel a ride in progress; complete it instead")
+)
+
+type CancellationParty string
+
+const (
+	CancelledByRider  CancellationParty = "RIDER"
+	CancelledByDriver CancellationParty = "DRIVER"
+	CancelledBySystem CancellationParty = "SYSTEM"
+)
+
+func (p CancellationParty) valid() bool {
+	return p == CancelledByRider || p == CancelledByDriver || p == CancelledBySystem
+}
+
+type Cancellation struct {
+	By     CancellationParty
+	Reason string
+	At     time.Time
+	Fee    Money
+}
+
+// CancellationPolicy decides what a cancellation costs the rider.
+// It returns the fee and the line item label to show on the fare breakdown.
+type CancellationPolicy interface {
+	CancellationFee(trip *Trip, by CancellationParty, at time.Time) (Money, string)
+}
+
+// StandardCancellationPolicy charges riders who cancel late:
+//   - a no-show fee once the driver has waited NoShowWait at pickup
+//   - free within FreeWindow of the request
+//   - LateCancelFee once a driver is assigned
+//
+// Drivers and the system never cause a fee. A rider can't cancel once the
+// ride has started, so the ride is completed and metered instead.
+type StandardCancellationPolicy struct {
+	FreeWindow    time.Duration
+	LateCancelFee Money
+	NoShowWait    time.Duration
+	NoShowFee     Money
+}
+
+// DefaultCancellationPolicy charges its fees in currency, which must be the
+// fare currency so they can be settled like fares.
+func DefaultCancellationPolicy(currency Currency) StandardCancellationPolicy {
+	return StandardCancellationPolicy{
+		FreeWindow:    2 * time.Minute,
+		LateCancelFee: MustParseMoney("5.00", currency),
+		NoShowWait:    5 * time.Minute,
+		NoShowFee:     MustParseMoney("7.50", currency),
+	}
+}
+
+func (p StandardCancellationPolicy) CancellationFee(trip *Trip, by CancellationParty, at time.Time) (Money, string) {
+	if by != CancelledByRider {
+		return Money{}, ""
+	}
+	if !trip.ArrivedAt.IsZero() && at.Sub(trip.ArrivedAt) >= p.NoShowWait {
+		return p.NoShowFee, "No-show fee"
+	}
+	if at.Sub(trip.RequestedAt) <= p.FreeWindow {
+		return Money{}, ""
+	}
+	if trip.State == DriverAssigned {
+		return p.LateCancelFee, "Cancellation fee"
+	}
+	return Money{}, ""
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoDriverAvailable = errors.New("no available driver nearby")
+	ErrNoDriverLocation  = errors.New("no driver position to route from")
+)
+
+// Dispatcher tracks the fleet and matches requested trips to the closest
+// eligible driver. Available drivers are kept in a GeoIndex by position.
+// All matching happens under a single lock, so a driver handed to one trip
+// is gone from the index before the next trip looks for candidates.
+type Dispatcher struct {
+	clock  Clock
+	policy DriverPolicy
+
+	mu      sync.Mutex
+	index   *GeoIndex
+	drivers map[string]*Driver
+	// pending holds the IDs of requests counted as open demand. Their state
+	// is read through requests, so a trip that moved on is never counted.
+	pending  map[string]bool
+	requests func(id string) (*Trip, error)
+	// reserved holds drivers with an open offer, keyed by driver ID, so no
+	// other trip is offered to them meanwhile.
+	reserved map[string]string
+	// avoid, if set, vetoes a rider/driver pairing, e.g. after a 1-star rating.
+	avoid func(rider *User, driver *Driver) bool
+	// eta, if set, ranks candidates by drive time to the pickup instead of
+	// straight-line distance.
+	eta ETAProvider
+}
+
+func NewDispatcher(clock Clock, policy DriverPolicy) *Dispatcher {
+	return &Dispatcher{
+		clock:    clock,
+		policy:   policy,
+		index:    NewGeoIndex(),
+		drivers:  make(map[string]*Driver),
+		pending:  make(map[string]bool),
+		reserved: make(map[string]string),
+	}
+}
+
+// GoOnline makes the driver available at loc, starting or resuming their
+// shift per the DriverPolicy. A driver already available just reports
+// their position; one on a trip gets ErrDriverOnTrip and stays as is.
+func (d *Dispatcher) GoOnline(driver *Driver, loc *Location) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	existing, ok := d.drivers[driver.ID]
+	switch {
+	case !ok:
+		d.policy.resumeShift(driver, nil, now)
+	case existing.Status == DriverOffline:
+		d.policy.resumeShift(driver, existing, now)
+	case existing.Status == DriverAvailable:
+		existing.Location = loc
+		existing.LastHeartbeat = now
+		if _, held := d.reserved[existing.ID]; !held {
+			d.index.Upsert(existing)
+		}
+		return nil
+	default:
+		return ErrDriverOnTrip
+	}
+	d.drivers[driver.ID] = driver
+	driver.Location = loc
+	driver.LastHeartbeat = now
+	driver.Status = DriverAvailable
+	d.index.Upsert(driver)
+	return nil
+}
+
+func (d *Dispatcher) GoOffline(driverID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok {
+		return ErrDriverNotFound
+	}
+	driver.Status = DriverOffline
+	driver.OfflineAt = d.clock.Now()
+	d.index.Remove(driverID)
+	delete(d.reserved, driverID)
+	return nil
+}
+
+// Heartbeat records the driver's latest position. Offline drivers must go
+// online again first.
+func (d *Dispatcher) Heartbeat(driverID string, loc *Location) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok {
+		return ErrDriverNotFound
+	}
+	if driver.Status == DriverOffline {
+		return ErrDriverUnavailable
+	}
+	driver.Location = loc
+	driver.LastHeartbeat = d.clock.Now()
+	if _, held := d.reserved[driverID]; driver.Status == DriverAvailable && !held {
+		d.index.Upsert(driver)
+	}
+	return nil
+}
+
+// ExpireStaleDrivers takes available drivers whose heartbeats stopped
+// offline. Drivers on a trip are left alone until the trip ends.
+func (d *Dispatcher) ExpireStaleDrivers() []*Driver {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	var expired []*Driver
+	for _, driver := range d.drivers {
+		if driver.Status == DriverAvailable && now.Sub(driver.LastHeartbeat) > d.policy.HeartbeatTTL {
+			driver.Status = DriverOffline
+			driver.OfflineAt = now
+			d.index.Remove(driver.ID)
+			expired = append(expired, driver)
+		}
+	}
+	return expired
+}
+
+// WatchHeartbeats runs ExpireStaleDrivers every interval until ctx is cancelled.
+func (d *Dispatcher) WatchHeartbeats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.ExpireStaleDrivers()
+		}
+	}
+}
+
+// DriverLocation returns a copy of the driver's last reported position.
+func (d *Dispatcher) DriverLocation(driverID string) (*Location, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok {
+		return nil, ErrDriverNotFound
+	}
+	if driver.Location == nil {
+		return nil, nil
+	}
+	loc := *driver.Location
+	return &loc, nil
+}
+
+// Driver returns a snapshot of the driver.
+func (d *Dispatcher) Driver(driverID string) (*Driver, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok {
+		return nil, ErrDriverNotFound
+	}
+	return driver.snapshot(), nil
+}
+
+// StartDriverTrip marks the driver as carrying a rider.
+func (d *Dispatcher) StartDriverTrip(driverID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if driver, ok := d.drivers[driverID]; ok {
+		driver.Status = DriverOnTrip
+	}
+}
+
+// ReleaseDriver makes a driver available again after a trip ends, unless
+// they went offline meanwhile.
+func (d *Dispatcher) ReleaseDriver(driverID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok || driver.Status == DriverOffline {
+		return
+	}
+	delete(d.reserved, driverID)
+	driver.Status = DriverAvailable
+	if driver.Location != nil {
+		d.index.Upsert(driver)
+	}
+}
+
+// NearestDrivers returns up to k eligible drivers for the vehicle class
+// within radiusMiles of pickup, closest first.
+func (d *Dispatcher) NearestDrivers(pickup *Location, class VehicleClass, k int, radiusMiles float64) []*Driver {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var drivers []*Driver
+	for _, candidate := range d.nearestEligible(pickup, class, nil, nil, k, radiusMiles) {
+		drivers = append(drivers, candidate.driver.snapshot())
+	}
+	return drivers
+}
+
+// AvoidPairs registers a filter that keeps the given rider and driver apart.
+func (d *Dispatcher) AvoidPairs(avoid func(rider *User, driver *Driver) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.avoid = avoid
+}
+
+// RankByETA orders match candidates by drive time to the pickup, e.g. over
+// a road graph, so a driver across a river loses to one a bit further away.
+func (d *Dispatcher) RankByETA(eta ETAProvider) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.eta = eta
+}
+
+// nearestEligible runs the index query with every matching rule applied:
+// driver eligibility, open reservations, the exclude set and the pair filter.
+// The k nearest by distance are then re-ranked by ETA if one is set.
+func (d *Dispatcher) nearestEligible(pickup *Location, class VehicleClass, rider *User, exclude map[string]bool, k int, radiusMiles float64) []driverDistance {
+	now := d.clock.Now()
+	candidates := d.index.Nearest(pickup, k, radiusMiles, func(driver *Driver) bool {
+		if _, held := d.reserved[driver.ID]; held || exclude[driver.ID] {
+			return false
+		}
+		if rider != nil && d.avoid != nil && d.avoid(rider, driver) {
+			return false
+		}
+		return d.policy.Eligible(driver, class, now)
+	})
+	if d.eta == nil || len(candidates) < 2 {
+		return candidates
+	}
+
+	minutes := make(map[string]float64, len(candidates))
+	for _, candidate := range candidates {
+		minutes[candidate.driver.ID] = d.eta.DurationMinutes(candidate.driver.Location, pickup)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return minutes[candidates[i].driver.ID] < minutes[candidates[j].driver.ID]
+	})
+	return candidates
+}
+
+// Reserve holds the closest eligible driver not in exclude for an offer on
+// the trip. The driver stays AVAILABLE but is hidden from matching until
+// the reservation is confirmed or cancelled.
+func (d *Dispatcher) Reserve(trip *Trip, exclude map[string]bool, k int, radiusMiles float64) (*Driver, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	candidates := d.nearestEligible(trip.PickupLocation, trip.VehicleClass, trip.Rider, exclude, k, radiusMiles)
+	if len(candidates) == 0 {
+		return nil, ErrNoDriverAvailable
+	}
+
+	driver := candidates[0].driver
+	d.reserved[driver.ID] = trip.ID
+	d.index.Remove(driver.ID)
+	return driver.snapshot(), nil
+}
+
+// AssignReserved assigns the trip to the driver holding its reservation and
+// sends them en route. It fails with ErrDriverUnavailable if the reservation
+// was cancelled or the driver went offline while the offer was open.
+func (d *Dispatcher) AssignReserved(trip *Trip, driverID string) (*Driver, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	driver, ok := d.drivers[driverID]
+	if !ok || d.reserved[driverID] != trip.ID || driver.Status != DriverAvailable {
+		return nil, ErrDriverUnavailable
+	}
+	if err := trip.AssignDriver(driver, d.clock.Now()); err != nil {
+		return nil, err
+	}
+	delete(d.reserved, driverID)
+	delete(d.pending, trip.ID)
+	driver.Status = DriverEnRoute
+	return driver.snapshot(), nil
+}
+
+// restoreReservation undoes AssignReserved when the trip could not be saved.
+func (d *Dispatcher) restoreReservation(driverID, tripID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if driver, ok := d.drivers[driverID]; ok && driver.Status == DriverEnRoute {
+		driver.Status = DriverAvailable
+		d.reserved[driverID] = tripID
+	}
+}
+
+// CancelReservation puts a driver whose offer was declined or expired back into matching.
+func (d *Dispatcher) CancelReservation(driverID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, held := d.reserved[driverID]; !held {
+		return
+	}
+	delete(d.reserved, driverID)
+	if driver, ok := d.drivers[driverID]; ok && driver.Status == DriverAvailable && driver.Location != nil {
+		d.index.Upsert(driver)
+	}
+}
+
+// AssignNearest picks the closest of the k nearest eligible drivers within
+// radiusMiles and assigns it to the trip.
+func (d *Dispatcher) AssignNearest(trip *Trip, k int, radiusMiles float64) (*Driver, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !trip.State.CanTransitionTo(DriverAssigned) {
+		return nil, ErrInvalidTransition{From: trip.State, To: DriverAssigned}
+	}
+	candidates := d.nearestEligible(trip.PickupLocation, trip.VehicleClass, trip.Rider, nil, k, radiusMiles)
+	if len(candidates) == 0 {
+		return nil, ErrNoDriverAvailable
+	}
+
+	driver := candidates[0].driver
+	if err := trip.AssignDriver(driver, d.clock.Now()); err != nil {
+		return nil, err
+	}
+	driver.Status = DriverEnRoute
+	d.index.Remove(driver.ID)
+	delete(d.pending, trip.ID)
+	return driver.snapshot(), nil
+}
+
+// ReadRequestsFrom sets where ZoneStats loads tracked requests from,
+// usually TripService.GetTrip. Call it before serving.
+func (d *Dispatcher) ReadRequestsFrom(get func(id string) (*Trip, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.requests = get
+}
+
+// TrackRequest counts the trip as open demand while it waits for a driver.
+func (d *Dispatcher) TrackRequest(tripID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending[tripID] = true
+}
+
+func (d *Dispatcher) DropRequest(tripID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, tripID)
+}
+
+// ZoneStats reports open requests and available drivers in a pricing zone.
+// A tracked request is open while it is Requested or Offered; any other
+// request is dropped. Requests are loaded outside the dispatcher lock.
+func (d *Dispatcher) ZoneStats(inZone func(loc *Location) bool) (openTrips, availableDrivers int) {
+	d.mu.Lock()
+	ids := make([]string, 0, len(d.pending))
+	for id := range d.pending {
+		ids = append(ids, id)
+	}
+	for _, driver := range d.drivers {
+		if driver.Status == DriverAvailable && driver.Location != nil && inZone(driver.Location) {
+			availableDrivers++
+		}
+	}
+	get := d.requests
+	d.mu.Unlock()
+
+	if get == nil {
+		return 0, availableDrivers
+	}
+	for _, id := range ids {
+		trip, err := get(id)
+		if err != nil || (trip.State != Requested && trip.State != Offered) {
+			d.DropRequest(id)
+			continue
+		}
+		if inZone(trip.PickupLocation) {
+			openTrips++
+		}
+	}
+	return openTrips, availableDrivers
+}
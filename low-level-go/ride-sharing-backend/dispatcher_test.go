@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGoOnlineLeavesBusyDriverOnTrip(t *testing.T) {
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	dispatcher.GoOnline(&Driver{ID: "d1", Vehicle: Vehicle{Class: Economy}}, testPickup)
+	for _, id := range []string{"t1", "t2"} {
+		if _, err := trips.RequestTrip(id, &User{ID: "r-" + id}, testPickup, testDropoff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := trips.AssignNearestDriver("t1", dispatcher, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	err := dispatcher.GoOnline(&Driver{ID: "d1", Vehicle: Vehicle{Class: Economy}}, testPickup)
+	if !errors.Is(err, ErrDriverOnTrip) {
+		t.Fatalf("going online en route: got %v, want ErrDriverOnTrip", err)
+	}
+	if driver, _ := dispatcher.Driver("d1"); driver.Status != DriverEnRoute {
+		t.Errorf("status = %s, want EN_ROUTE", driver.Status)
+	}
+	if _, err := trips.AssignNearestDriver("t2", dispatcher, 1, 1); !errors.Is(err, ErrNoDriverAvailable) {
+		t.Errorf("second trip: got %v, want ErrNoDriverAvailable for a double-booked driver", err)
+	}
+}
+
+func TestShortBreakKeepsShiftTime(t *testing.T) {
+	clock := NewVirtualClock(time.Date(2026, 10, 13, 6, 0, 0, 0, time.UTC))
+	policy := DriverPolicy{HeartbeatTTL: time.Hour, MaxShift: 12 * time.Hour, MinRest: 8 * time.Hour}
+	dispatcher := NewDispatcher(clock, policy)
+	online := func() {
+		t.Helper()
+		if err := dispatcher.GoOnline(&Driver{ID: "d1", Vehicle: Vehicle{Class: Economy}}, testPickup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	available := func() bool { return len(dispatcher.NearestDrivers(testPickup, Economy, 1, 1)) == 1 }
+
+	online()
+	clock.Advance(11 * time.Hour)
+	dispatcher.GoOffline("d1")
+	clock.Advance(10 * time.Minute)
+	online()
+	clock.Advance(time.Hour)
+	if available() {
+		t.Error("a 10-minute break should not reset the 12 h shift limit")
+	}
+
+	dispatcher.GoOffline("d1")
+	clock.Advance(8 * time.Hour)
+	online()
+	if !available() {
+		t.Error("a full rest should start a new shift")
+	}
+}
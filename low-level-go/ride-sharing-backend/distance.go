@@ -0,0 +1,123 @@
+package main
+
+import "math"
+
+// DistanceProvider measures the distance between two points in miles.
+type DistanceProvider interface {
+	DistanceMiles(from, to *Location) float64
+}
+
+// Where a billed distance came from, as recorded on the fare.
+const (
+	DistanceFromGPS       = "GPS"
+	DistanceFromRoadGraph = "road graph"
+	DistanceStraightLine  = "straight line"
+)
+
+// distanceSourcer is implemented by providers that don't always measure
+// a straight line, such as a Router with a road graph.
+type distanceSourcer interface {
+	DistanceSource(from, to *Location) string
+}
+
+func distanceSourceOf(provider DistanceProvider, from, to *Location) string {
+	if sourcer, ok := provider.(distanceSourcer); ok {
+		return sourcer.DistanceSource(from, to)
+	}
+	return DistanceStraightLine
+}
+
+// ETAProvider estimates how long it takes to drive between two points, in minutes.
+type ETAProvider interface {
+	DurationMinutes(from, to *Location) float64
+}
+
+const (
+	earthRadiusMiles = 3958.8
+	metersPerMile    = 1609.344
+
+	// WGS-84 ellipsoid
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// HaversineDistance is the great-circle distance on a spherical earth.
+type HaversineDistance struct{}
+
+func (HaversineDistance) DistanceMiles(from, to *Location) float64 {
+	lat1, lat2 := toRadians(from.Latitude), toRadians(to.Latitude)
+	dLat := lat2 - lat1
+	dLon := toRadians(to.Longitude - from.Longitude)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// VincentyDistance is the geodesic distance on the WGS-84 ellipsoid. It is
+// accurate to well under a metre, at the cost of an iterative solve. Nearly
+// antipodal points may not converge; those fall back to Haversine.
+type VincentyDistance struct{}
+
+func (VincentyDistance) DistanceMiles(from, to *Location) float64 {
+	if from.Latitude == to.Latitude && from.Longitude == to.Longitude {
+		return 0
+	}
+
+	L := toRadians(to.Longitude - from.Longitude)
+	U1 := math.Atan((1 - wgs84F) * math.Tan(toRadians(from.Latitude)))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(toRadians(to.Latitude)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) +
+			(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+			A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+			B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+			deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return wgs84B * A * (sigma - deltaSigma) / metersPerMile
+		}
+	}
+	return HaversineDistance{}.DistanceMiles(from, to)
+}
+
+// ConstantSpeedETA assumes the whole trip is driven at SpeedMPH.
+type ConstantSpeedETA struct {
+	SpeedMPH float64
+	Distance DistanceProvider
+}
+
+func (e ConstantSpeedETA) DurationMinutes(from, to *Location) float64 {
+	distance := e.Distance
+	if distance == nil {
+		distance = HaversineDistance{}
+	}
+	return estimateDuration(distance.DistanceMiles(from, to), e.SpeedMPH)
+}
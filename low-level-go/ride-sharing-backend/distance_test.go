@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	lax = &Location{Latitude: 33.9425, Longitude: -118.4081}
+	jfk = &Location{Latitude: 40.6398, Longitude: -73.7789}
+	lhr = &Location{Latitude: 51.4700, Longitude: -0.4543}
+	cdg = &Location{Latitude: 49.0097, Longitude: 2.5479}
+	syd = &Location{Latitude: -33.9399, Longitude: 151.1753}
+	mel = &Location{Latitude: -37.6690, Longitude: 144.8410}
+)
+
+// The reference distances are the WGS-84 geodesics between the airports,
+// rounded to 0.1 mile.
+func TestVincentyDistanceCityPairs(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  *Location
+		vincenty  float64
+		haversine float64
+	}{
+		{"LAX-JFK", lax, jfk, 2474.9, 2469.5},
+		{"LHR-CDG", lhr, cdg, 215.9, 215.6},
+		{"SYD-MEL", syd, mel, 438.8, 438.4},
+		{"JFK-LHR", jfk, lhr, 3451.8, 3442.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (VincentyDistance{}).DistanceMiles(tt.from, tt.to); math.Abs(got-tt.vincenty) > 0.05 {
+				t.Errorf("Vincenty = %.2f mi, want %.1f", got, tt.vincenty)
+			}
+			if got := (VincentyDistance{}).DistanceMiles(tt.to, tt.from); math.Abs(got-tt.vincenty) > 0.05 {
+				t.Errorf("Vincenty reversed = %.2f mi, want %.1f", got, tt.vincenty)
+			}
+			if got := (HaversineDistance{}).DistanceMiles(tt.from, tt.to); math.Abs(got-tt.haversine) > 0.05 {
+				t.Errorf("Haversine = %.2f mi, want %.1f", got, tt.haversine)
+			}
+		})
+	}
+}
+
+func TestVincentyDistanceEdgeCases(t *testing.T) {
+	if got := (VincentyDistance{}).DistanceMiles(lax, lax); got != 0 {
+		t.Errorf("same point = %v mi, want 0", got)
+	}
+
+	// Nearly antipodal points don't converge and fall back to Haversine
+	// rather than returning NaN.
+	from := &Location{Latitude: 0, Longitude: 0}
+	to := &Location{Latitude: 0.5, Longitude: 179.7}
+	got := (VincentyDistance{}).DistanceMiles(from, to)
+	want := (HaversineDistance{}).DistanceMiles(from, to)
+	if math.IsNaN(got) || math.Abs(got-want) > want*0.01 {
+		t.Errorf("near-antipodal = %.1f mi, want about %.1f", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrDriverNotFound    = errors.New("driver not found")
+	ErrDriverUnavailable = errors.New("driver is not available")
+	ErrDriverOnTrip      = errors.New("driver is on a trip")
+)
+
+type DriverStatus string
+
+const (
+	DriverOffline   DriverStatus = "OFFLINE"
+	DriverAvailable DriverStatus = "AVAILABLE"
+	DriverEnRoute   DriverStatus = "EN_ROUTE"
+	DriverOnTrip    DriverStatus = "ON_TRIP"
+)
+
+type Vehicle struct {
+	Make  string
+	Model string
+	Plate string
+	Class VehicleClass
+}
+
+type Driver struct {
+	ID             string
+	Name           string
+	Vehicle        Vehicle
+	Location       *Location
+	Status         DriverStatus
+	LastHeartbeat  time.Time
+	ShiftStartedAt time.Time
+	OfflineAt      time.Time // when the driver last went offline
+}
+
+// snapshot copies the driver for use outside the Dispatcher, which owns and
+// mutates the original under its lock. The position is left out; ask the
+// Dispatcher for it.
+func (d *Driver) snapshot() *Driver {
+	c := *d
+	c.Location = nil
+	return &c
+}
+
+// DriverPolicy sets when a driver stops being offered trips.
+type DriverPolicy struct {
+	// HeartbeatTTL is how long an available driver may go silent before
+	// being taken offline.
+	HeartbeatTTL time.Duration
+	// MaxShift is the longest a driver may stay online; past it they finish
+	// their current trip but get no new ones.
+	MaxShift time.Duration
+	// MinRest is the break that starts a new shift. A shorter one pauses
+	// the shift clock, so going offline briefly doesn't reset MaxShift.
+	MinRest time.Duration
+}
+
+func DefaultDriverPolicy() DriverPolicy {
+	return DriverPolicy{
+		HeartbeatTTL: 60 * time.Second,
+		MaxShift:     12 * time.Hour,
+		MinRest:      8 * time.Hour,
+	}
+}
+
+// resumeShift sets the shift start of a driver coming back online at now:
+// a fresh shift after a rest of at least MinRest, else the old shift with
+// the break left out.
+func (p DriverPolicy) resumeShift(driver, previous *Driver, now time.Time) {
+	if previous == nil || previous.ShiftStartedAt.IsZero() || now.Sub(previous.OfflineAt) >= p.MinRest {
+		driver.ShiftStartedAt = now
+		return
+	}
+	driver.ShiftStartedAt = previous.ShiftStartedAt.Add(now.Sub(previous.OfflineAt))
+}
+
+// Eligible reports whether the driver may be matched to a trip of the given class at now.
+func (p DriverPolicy) Eligible(driver *Driver, class VehicleClass, now time.Time) bool {
+	switch {
+	case driver.Status != DriverAvailable, driver.Location == nil:
+		return false
+	case driver.Vehicle.Class != class:
+		return false
+	case now.Sub(driver.LastHeartbeat) > p.HeartbeatTTL:
+		return false
+	case now.Sub(driver.ShiftStartedAt) >= p.MaxShift:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTripRejected        = errors.New("trip was rejected in fraud review")
+	ErrNotOnHold           = errors.New("trip is not held for review")
+	ErrInvalidReviewStatus = errors.New("review decision must be CLEARED or REJECTED")
+)
+
+type AnomalyKind string
+
+const (
+	AnomalyImpossibleSpeed AnomalyKind = "IMPOSSIBLE_SPEED"
+	AnomalySameSpotFare    AnomalyKind = "SAME_SPOT_HIGH_FARE"
+	AnomalyRepeatedPair    AnomalyKind = "REPEATED_PAIR"
+)
+
+type TripFlag struct {
+	Kind   AnomalyKind
+	Detail string
+	At     time.Time
+}
+
+// ReviewStatus is the fraud side of a trip, tracked next to its TripState
+// and PaymentStatus. A HELD trip is charged, but the driver is not paid out
+// until a reviewer clears it; a REJECTED one is neither charged, if it
+// wasn't yet, nor paid out.
+type ReviewStatus string
+
+const (
+	ReviewHeld     ReviewStatus = "HELD"
+	ReviewCleared  ReviewStatus = "CLEARED"
+	ReviewRejected ReviewStatus = "REJECTED"
+)
+
+type TripReview struct {
+	Status     ReviewStatus
+	Flags      []TripFlag
+	Reviewer   string
+	Note       string
+	ReviewedAt time.Time
+}
+
+func (r *TripReview) clone() *TripReview {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Flags = append([]TripFlag(nil), r.Flags...)
+	return &c
+}
+
+func (r *TripReview) flagged(kind AnomalyKind) bool {
+	if r == nil {
+		return false
+	}
+	for _, flag := range r.Flags {
+		if flag.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Flag records new anomalies and holds the trip for review, even if an
+// earlier review cleared it. Kinds already flagged are ignored.
+func (t *Trip) Flag(flags []TripFlag) bool {
+	var added []TripFlag
+	for _, flag := range flags {
+		if !t.Review.flagged(flag.Kind) {
+			added = append(added, flag)
+		}
+	}
+	if len(added) == 0 {
+		return false
+	}
+	if t.Review == nil {
+		t.Review = &TripReview{}
+	}
+	t.Review.Status = ReviewHeld
+	t.Review.Flags = append(t.Review.Flags, added...)
+	return true
+}
+
+// ResolveReview records a reviewer's decision on a held trip.
+func (t *Trip) ResolveReview(status ReviewStatus, reviewer, note string, at time.Time) error {
+	if status != ReviewCleared && status != ReviewRejected {
+		return ErrInvalidReviewStatus
+	}
+	if t.Review == nil || t.Review.Status != ReviewHeld {
+		return ErrNotOnHold
+	}
+	t.Review.Status = status
+	t.Review.Reviewer = reviewer
+	t.Review.Note = note
+	t.Review.ReviewedAt = at
+	return nil
+}
+
+type AnomalyConfig struct {
+	// MaxSpeedMPH is faster than any car on the route could go. It is well
+	// above RouteFilter's limit, which only drops noisy pings from metering.
+	MaxSpeedMPH float64
+	// A trip whose first and last positions are within SameSpotMiles is
+	// suspicious if its fare is at least SameSpotFare. Fares in another
+	// currency are not compared.
+	SameSpotMiles float64
+	SameSpotFare  Money
+	// MaxPairTrips is how many trips one rider may complete with one driver
+	// within PairWindow before the next is flagged.
+	MaxPairTrips int
+	PairWindow   time.Duration
+}
+
+func DefaultAnomalyConfig() AnomalyConfig {
+	return AnomalyConfig{
+		MaxSpeedMPH:   150,
+		SameSpotMiles: 0.1,
+		SameSpotFare:  NewMoney(2500, USD),
+		MaxPairTrips:  3,
+		PairWindow:    24 * time.Hour,
+	}
+}
+
+// AnomalyDetector inspects every saved trip, through TripService.OnTripSaved,
+// and holds suspicious ones for review. Pair counts and the review queue
+// are kept in memory and start empty on restart.
+type AnomalyDetector struct {
+	trips    *TripService
+	distance DistanceProvider
+	clock    Clock
+	config   AnomalyConfig
+
+	mu    sync.Mutex
+	pairs map[string]map[string]time.Time // rider+driver -> trip ID -> completed at
+	held  map[string]time.Time            // trip ID -> first flagged at
+}
+
+func NewAnomalyDetector(trips *TripService, clock Clock, config AnomalyConfig) *AnomalyDetector {
+	return &AnomalyDetector{
+		trips:    trips,
+		distance: HaversineDistance{},
+		clock:    clock,
+		config:   config,
+		pairs:    make(map[string]map[string]time.Time),
+		held:     make(map[string]time.Time),
+	}
+}
+
+// TripSaved is registered with TripService.OnTripSaved. Placing a hold is
+// itself a save, so this runs again on the held trip and finds nothing new.
+func (d *AnomalyDetector) TripSaved(trip *Trip) {
+	flags := d.inspect(trip)
+	if len(flags) == 0 {
+		return
+	}
+	// If the save fails, the next save of the trip inspects it again.
+	d.trips.update(trip.ID, func(t *Trip) error {
+		if !t.Flag(flags) {
+			return errNothingToFlag
+		}
+		return nil
+	})
+}
+
+var errNothingToFlag = errors.New("no new anomalies")
+
+// inspect runs the checks that apply to the trip's state and updates the
+// review queue.
+func (d *AnomalyDetector) inspect(trip *Trip) []TripFlag {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if trip.Review != nil && trip.Review.Status == ReviewHeld {
+		if _, ok := d.held[trip.ID]; !ok {
+			d.held[trip.ID] = trip.Review.Flags[0].At
+		}
+	} else {
+		delete(d.held, trip.ID)
+	}
+
+	var flags []TripFlag
+	add := func(kind AnomalyKind, detail string) {
+		if !trip.Review.flagged(kind) {
+			flags = append(flags, TripFlag{Kind: kind, Detail: detail, At: d.clock.Now()})
+		}
+	}
+	if detail, ok := d.impossibleSpeed(trip.Route); ok {
+		add(AnomalyImpossibleSpeed, detail)
+	}
+	if trip.State == Completed {
+		if detail, ok := d.sameSpot(trip); ok {
+			add(AnomalySameSpotFare, detail)
+		}
+		if detail, ok := d.repeatedPair(trip); ok {
+			add(AnomalyRepeatedPair, detail)
+		}
+	}
+	return flags
+}
+
+// impossibleSpeed looks at the raw pings, including the ones RouteFilter
+// would drop: a jump no car could make is evidence of a spoofed trace.
+func (d *AnomalyDetector) impossibleSpeed(route []LocationPing) (string, bool) {
+	pings := append([]LocationPing(nil), route...)
+	sort.SliceStable(pings, func(i, j int) bool {
+		return pings[i].Timestamp.Before(pings[j].Timestamp)
+	})
+	for i := 1; i < len(pings); i++ {
+		miles := d.distance.DistanceMiles(pings[i-1].Location, pings[i].Location)
+		elapsed := pings[i].Timestamp.Sub(pings[i-1].Timestamp)
+		if miles < minMovementMiles {
+			continue
+		}
+		if elapsed <= 0 {
+			return fmt.Sprintf("%.2f mi in no time at %s", miles, pings[i].Timestamp.Format(time.RFC3339)), true
+		}
+		if mph := miles / elapsed.Hours(); mph > d.config.MaxSpeedMPH {
+			return fmt.Sprintf("%.0f mph between pings at %s and %s", mph,
+				pings[i-1].Timestamp.Format(time.RFC3339), pings[i].Timestamp.Format(time.RFC3339)), true
+		}
+	}
+	return "", false
+}
+
+// sameSpot checks whether the trip ever got away from where it started,
+// following the cleaned pings, or the pickup, stops and dropoff if there
+// are none. A round trip through a stop ends where it started but isn't
+// suspicious.
+func (d *AnomalyDetector) sameSpot(trip *Trip) (string, bool) {
+	points := trip.Path()
+	if len(trip.Route) > 0 {
+		points = nil
+		for _, ping := range DefaultRouteFilter().Clean(trip.Route) {
+			points = append(points, ping.Location)
+		}
+	}
+	if points[0] == nil {
+		return "", false
+	}
+	miles := 0.0
+	for _, point := range points[1:] {
+		if point != nil {
+			miles = math.Max(miles, d.distance.DistanceMiles(points[0], point))
+		}
+	}
+	if miles > d.config.SameSpotMiles {
+		return "", false
+	}
+	if cmp, err := trip.Fare.Cmp(d.config.SameSpotFare); err != nil || cmp < 0 {
+		return "", false
+	}
+	return fmt.Sprintf("fare %s without going more than %.2f mi from the start", trip.Fare, miles), true
+}
+
+// repeatedPair counts the rider's completed trips with this driver in the
+// window ending at this one. Callers hold mu.
+func (d *AnomalyDetector) repeatedPair(trip *Trip) (string, bool) {
+	if trip.Rider == nil || trip.Driver == nil {
+		return "", false
+	}
+	key := trip.Rider.ID + "\x00" + trip.Driver.ID
+	trips, ok := d.pairs[key]
+	if !ok {
+		trips = make(map[string]time.Time)
+		d.pairs[key] = trips
+	}
+	trips[trip.ID] = trip.CompletedAt
+
+	since := trip.CompletedAt.Add(-d.config.PairWindow)
+	count := 0
+	for id, at := range trips {
+		switch {
+		case !at.After(since):
+			delete(trips, id)
+		case !at.After(trip.CompletedAt):
+			count++
+		}
+	}
+	if count <= d.config.MaxPairTrips {
+		return "", false
+	}
+	return fmt.Sprintf("rider %s and driver %s completed %d trips within %s", trip.Rider.ID, trip.Driver.ID, count, d.config.PairWindow), true
+}
+
+// Held lists the trips waiting for review, oldest flag first.
+func (d *AnomalyDetector) Held() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ids := make([]string, 0, len(d.held))
+	for id := range d.held {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return d.held[ids[i]].Before(d.held[ids[j]])
+	})
+	return ids
+}
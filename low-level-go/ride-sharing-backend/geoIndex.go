@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// geoCellSize is the edge of a grid cell in degrees (~0.7 miles of latitude).
+const geoCellSize = 0.01
+
+// geoLonCells is the number of cell columns around the globe.
+var geoLonCells = int(math.Round(360 / geoCellSize))
+
+type geoCell struct {
+	lat int
+	lon int
+}
+
+func cellFor(loc *Location) geoCell {
+	return geoCell{
+		lat: int(math.Floor(loc.Latitude / geoCellSize)),
+		lon: wrapLon(int(math.Floor(loc.Longitude / geoCellSize))),
+	}
+}
+
+// GeoIndex is a fixed-size grid over lat/lon that buckets drivers by cell,
+// so a radius query only has to look at the cells around the pickup.
+type GeoIndex struct {
+	cells   map[geoCell]map[string]*Driver
+	drivers map[string]geoCell
+}
+
+func NewGeoIndex() *GeoIndex {
+	return &GeoIndex{
+		cells:   make(map[geoCell]map[string]*Driver),
+		drivers: make(map[string]geoCell),
+	}
+}
+
+func (g *GeoIndex) Upsert(driver *Driver) {
+	g.Remove(driver.ID)
+	cell := cellFor(driver.Location)
+	bucket, ok := g.cells[cell]
+	if !ok {
+		bucket = make(map[string]*Driver)
+		g.cells[cell] = bucket
+	}
+	bucket[driver.ID] = driver
+	g.drivers[driver.ID] = cell
+}
+
+func (g *GeoIndex) Remove(driverID string) {
+	cell, ok := g.drivers[driverID]
+	if !ok {
+		return
+	}
+	delete(g.cells[cell], driverID)
+	if len(g.cells[cell]) == 0 {
+		delete(g.cells, cell)
+	}
+	delete(g.drivers, driverID)
+}
+
+type driverDistance struct {
+	driver *Driver
+	miles  float64
+}
+
+// wrapLon maps a cell column past ±180° back onto the globe, so searches
+// near the antimeridian look at the cells on the other side.
+func wrapLon(lon int) int {
+	half := geoLonCells / 2
+	return ((lon+half)%geoLonCells+geoLonCells)%geoLonCells - half
+}
+
+// Nearest returns up to k drivers within radiusMiles of loc that pass
+// filter, closest first. k <= 0 means no limit. A nil filter accepts every
+// driver.
+func (g *GeoIndex) Nearest(loc *Location, k int, radiusMiles float64, filter func(*Driver) bool) []driverDistance {
+	latSpan := radiusMiles / 69.0
+	lonSpan := radiusMiles / (69.0 * math.Max(math.Cos(loc.Latitude*math.Pi/180), 0.01))
+
+	// Columns are left unwrapped here so the range can run across ±180°.
+	minCell := cellFor(&Location{Latitude: loc.Latitude - latSpan})
+	maxCell := cellFor(&Location{Latitude: loc.Latitude + latSpan})
+	minCell.lon = int(math.Floor((loc.Longitude - lonSpan) / geoCellSize))
+	maxCell.lon = int(math.Floor((loc.Longitude + lonSpan) / geoCellSize))
+	if maxCell.lon-minCell.lon >= geoLonCells {
+		maxCell.lon = minCell.lon + geoLonCells - 1
+	}
+
+	var found []driverDistance
+	for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+		for lon := minCell.lon; lon <= maxCell.lon; lon++ {
+			for _, driver := range g.cells[geoCell{lat: lat, lon: wrapLon(lon)}] {
+				if filter != nil && !filter(driver) {
+					continue
+				}
+				miles := calculateDistance(loc, driver.Location)
+				if miles <= radiusMiles {
+					found = append(found, driverDistance{driver: driver, miles: miles})
+				}
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].miles == found[j].miles {
+			return found[i].driver.ID < found[j].driver.ID
+		}
+		return found[i].miles < found[j].miles
+	})
+	if k > 0 && len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func indexWith(drivers ...*Driver) *GeoIndex {
+	index := NewGeoIndex()
+	for _, driver := range drivers {
+		index.Upsert(driver)
+	}
+	return index
+}
+
+func TestNearestNonPositiveKMeansNoLimit(t *testing.T) {
+	index := indexWith(
+		&Driver{ID: "a", Location: &Location{Latitude: 37.7749, Longitude: -122.4194}},
+		&Driver{ID: "b", Location: &Location{Latitude: 37.7759, Longitude: -122.4194}},
+		&Driver{ID: "c", Location: &Location{Latitude: 37.7769, Longitude: -122.4194}},
+	)
+	pickup := &Location{Latitude: 37.7749, Longitude: -122.4194}
+
+	for _, k := range []int{0, -1} {
+		if got := index.Nearest(pickup, k, 1, nil); len(got) != 3 {
+			t.Errorf("k=%d: got %d drivers, want 3", k, len(got))
+		}
+	}
+	if got := index.Nearest(pickup, 2, 1, nil); len(got) != 2 || got[0].driver.ID != "a" {
+		t.Errorf("k=2: got %v, want a then b", got)
+	}
+}
+
+func TestNearestAcrossAntimeridian(t *testing.T) {
+	index := indexWith(&Driver{ID: "east", Location: &Location{Latitude: -16.5, Longitude: 179.999}})
+
+	for _, pickup := range []*Location{
+		{Latitude: -16.5, Longitude: -179.999},
+		{Latitude: -16.5, Longitude: 180.001},
+	} {
+		got := index.Nearest(pickup, 1, 1, nil)
+		if len(got) != 1 || got[0].driver.ID != "east" {
+			t.Errorf("pickup %+v: got %v, want the driver across ±180°", *pickup, got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrOutsideServiceArea = errors.New("outside the service area")
+
+type ZoneKind string
+
+const (
+	// ServiceAreaZone polygons together make up where trips may start and end.
+	ServiceAreaZone ZoneKind = "SERVICE_AREA"
+	AirportZone     ZoneKind = "AIRPORT"
+	CongestionZone  ZoneKind = "CONGESTION"
+)
+
+// Ring is a closed polygon boundary. The last point may repeat the first.
+type Ring []Location
+
+// Polygon is an outer ring followed by any holes cut out of it.
+type Polygon []Ring
+
+// Zone is a named area. Surcharge, if positive, is added to fares that
+// pick up or drop off inside it.
+type Zone struct {
+	Name      string
+	Kind      ZoneKind
+	Polygons  []Polygon
+	Surcharge Money
+}
+
+func (z Zone) Contains(loc *Location) bool {
+	for _, polygon := range z.Polygons {
+		if polygon.contains(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Polygon) contains(loc *Location) bool {
+	if len(p) == 0 || !p[0].contains(loc) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains casts a ray east from loc and counts boundary crossings. Zones
+// are city-sized, so treating degrees as planar coordinates is fine.
+func (r Ring) contains(loc *Location) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a.Latitude > loc.Latitude) == (b.Latitude > loc.Latitude) {
+			continue
+		}
+		crossing := a.Longitude + (loc.Latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+		if loc.Longitude < crossing {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Geofence holds the service area and the named zones inside it. With no
+// service area polygons, trips are allowed anywhere.
+type Geofence struct {
+	Zones []Zone
+}
+
+// InServiceArea reports whether loc is inside any service area polygon.
+func (g *Geofence) InServiceArea(loc *Location) bool {
+	restricted := false
+	for _, zone := range g.Zones {
+		if zone.Kind != ServiceAreaZone {
+			continue
+		}
+		restricted = true
+		if zone.Contains(loc) {
+			return true
+		}
+	}
+	return !restricted
+}
+
+// ZonesAt returns the named zones containing loc, service areas excluded.
+func (g *Geofence) ZonesAt(loc *Location) []Zone {
+	var zones []Zone
+	for _, zone := range g.Zones {
+		if zone.Kind != ServiceAreaZone && zone.Contains(loc) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+func (g *Geofence) zoneNamesAt(loc *Location) []string {
+	var names []string
+	for _, zone := range g.ZonesAt(loc) {
+		names = append(names, zone.Name)
+	}
+	return names
+}
+
+// Admit rejects a trip whose pickup, stops or dropoff are outside the
+// service area and tags it with the zones of both ends for pricing and
+// analytics.
+func (g *Geofence) Admit(trip *Trip) error {
+	if !g.InServiceArea(trip.PickupLocation) {
+		return fmt.Errorf("pickup %s: %w", formatLocation(trip.PickupLocation), ErrOutsideServiceArea)
+	}
+	if !g.InServiceArea(trip.DropoffLocation) {
+		return fmt.Errorf("dropoff %s: %w", formatLocation(trip.DropoffLocation), ErrOutsideServiceArea)
+	}
+	for _, stop := range trip.Waypoints {
+		if err := g.AdmitStop(stop.Location); err != nil {
+			return err
+		}
+	}
+	trip.PickupZones = g.zoneNamesAt(trip.PickupLocation)
+	trip.DropoffZones = g.zoneNamesAt(trip.DropoffLocation)
+	return nil
+}
+
+// AdmitStop rejects a stop or new destination outside the service area.
+func (g *Geofence) AdmitStop(loc *Location) error {
+	if !g.InServiceArea(loc) {
+		return fmt.Errorf("stop %s: %w", formatLocation(loc), ErrOutsideServiceArea)
+	}
+	return nil
+}
+
+func formatLocation(loc *Location) string {
+	return fmt.Sprintf("(%.5f, %.5f)", loc.Latitude, loc.Longitude)
+}
+
+// geoJSONFeatureCollection is the subset of GeoJSON (RFC 7946) we read:
+// Polygon and MultiPolygon features whose properties name the zone.
+// Coordinates are [longitude, latitude].
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Properties struct {
+		Name      string      `json:"name"`
+		Kind      ZoneKind    `json:"kind"`
+		Surcharge json.Number `json:"surcharge"`
+		Currency  Currency    `json:"currency"`
+	} `json:"properties"`
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// ParseGeofence reads GeoJSON zones. Surcharges are in currency, the
+// currency fares are priced in, unless a zone names its own; a zone priced
+// in any other currency is rejected, since its surcharge could never be
+// added to a fare.
+func ParseGeofence(data []byte, currency Currency) (*Geofence, error) {
+	var collection geoJSONFeatureCollection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("parse geofence: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("parse geofence: want a FeatureCollection, got %q", collection.Type)
+	}
+
+	geofence := &Geofence{}
+	for i, feature := range collection.Features {
+		props := feature.Properties
+		if props.Name == "" {
+			return nil, fmt.Errorf("parse geofence: feature %d has no name", i)
+		}
+		zone := Zone{Name: props.Name, Kind: props.Kind}
+		if zone.Kind == "" {
+			zone.Kind = ServiceAreaZone
+		}
+		if props.Surcharge != "" {
+			if props.Currency != "" && props.Currency != currency {
+				return nil, fmt.Errorf("parse geofence: zone %q: surcharge in %s, fares are in %s", zone.Name, props.Currency, currency)
+			}
+			surcharge, err := ParseMoney(props.Surcharge.String(), currency)
+			if err != nil {
+				return nil, fmt.Errorf("parse geofence: zone %q: %w", zone.Name, err)
+			}
+			zone.Surcharge = surcharge
+		}
+
+		var err error
+		zone.Polygons, err = parseGeoJSONPolygons(feature.Geometry.Type, feature.Geometry.Coordinates)
+		if err != nil {
+			return nil, fmt.Errorf("parse geofence: zone %q: %w", zone.Name, err)
+		}
+		geofence.Zones = append(geofence.Zones, zone)
+	}
+	return geofence, nil
+}
+
+func LoadGeofence(path string, currency Currency) (*Geofence, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGeofence(data, currency)
+}
+
+func parseGeoJSONPolygons(geometryType string, coordinates json.RawMessage) ([]Polygon, error) {
+	var raw [][][][]float64
+	switch geometryType {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		raw = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(coordinates, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry %q", geometryType)
+	}
+
+	polygons := make([]Polygon, 0, len(raw))
+	for _, rawPolygon := range raw {
+		var polygon Polygon
+		for _, rawRing := range rawPolygon {
+			if len(rawRing) < 3 {
+				return nil, errors.New("ring needs at least 3 positions")
+			}
+			ring := make(Ring, 0, len(rawRing))
+			for _, position := range rawRing {
+				if len(position) < 2 {
+					return nil, errors.New("position needs longitude and latitude")
+				}
+				ring = append(ring, Location{Latitude: position[1], Longitude: position[0]})
+			}
+			polygon = append(polygon, ring)
+		}
+		polygons = append(polygons, polygon)
+	}
+	return polygons, nil
+}
+
+// ZonePricingCalculator adds the surcharge of every zone the trip picks up
+// or drops off in, once per zone, to the Base fare.
+type ZonePricingCalculator struct {
+	Base  PriceCalculator
+	Zones *Geofence
+}
+
+func (c ZonePricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	return c.withSurcharges(trip, c.Base.CalculateFare(trip))
+}
+
+// Quote adds the zone surcharges to the base calculator's quote, so a
+// surge locked in by the base is kept.
+func (c ZonePricingCalculator) Quote(trip *Trip) FareBreakdown {
+	if quoter, ok := c.Base.(Quoter); ok {
+		return c.withSurcharges(trip, quoter.Quote(trip))
+	}
+	return c.CalculateFare(trip)
+}
+
+func (c ZonePricingCalculator) withSurcharges(trip *Trip, fare FareBreakdown) FareBreakdown {
+	for _, zone := range c.Zones.Zones {
+		if !zone.Surcharge.IsPositive() || zone.Kind == ServiceAreaZone {
+			continue
+		}
+		if zone.Contains(trip.PickupLocation) || zone.Contains(trip.DropoffLocation) {
+			fare.Add(FareSurcharge, zone.Name+" surcharge", zone.Surcharge)
+		}
+	}
+	return fare
+}
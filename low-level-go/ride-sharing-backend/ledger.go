@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrUnbalanced = errors.New("ledger transaction does not balance")
+
+const (
+	accountCommission = "platform:commission"
+	accountTaxPayable = "platform:tax_payable"
+	// accountPayoutsHeld holds driver earnings on trips under fraud review.
+	accountPayoutsHeld = "platform:payouts_held"
+)
+
+func riderAccount(riderID string) string {
+	return "rider:" + riderID
+}
+
+func driverAccount(driverID string) string {
+	return "driver:" + driverID
+}
+
+// Posting is one side of a double-entry line. Positive amounts are debits,
+// negative amounts credits.
+type Posting struct {
+	Account string
+	Amount  Money
+}
+
+// LedgerTransaction is a balanced set of postings: debits equal credits.
+type LedgerTransaction struct {
+	ID       int
+	TripID   string
+	At       time.Time
+	Memo     string
+	Postings []Posting
+}
+
+// balanced reports whether debits equal credits. Postings in different
+// currencies can never balance.
+func (tx LedgerTransaction) balanced() bool {
+	var sum Money
+	for _, posting := range tx.Postings {
+		var err error
+		if sum, err = sum.Add(posting.Amount); err != nil {
+			return false
+		}
+	}
+	return sum.IsZero()
+}
+
+// Ledger is an append-only, in-memory double-entry journal.
+type Ledger struct {
+	mu           sync.Mutex
+	transactions []LedgerTransaction
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{}
+}
+
+// Post appends the transaction. An unbalanced transaction is rejected with
+// ErrUnbalanced and nothing is posted.
+func (l *Ledger) Post(tx LedgerTransaction) (LedgerTransaction, error) {
+	if !tx.balanced() {
+		return LedgerTransaction{}, fmt.Errorf("%w: trip %s: %+v", ErrUnbalanced, tx.TripID, tx.Postings)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tx.ID = len(l.transactions) + 1
+	l.transactions = append(l.transactions, tx)
+	return tx, nil
+}
+
+// Transactions returns the transactions dated in [from, to).
+func (l *Ledger) Transactions(from, to time.Time) []LedgerTransaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result []LedgerTransaction
+	for _, tx := range l.transactions {
+		if !tx.At.Before(from) && tx.At.Before(to) {
+			result = append(result, tx)
+		}
+	}
+	return result
+}
+
+// Balance sums every posting to the account. Postings in more than one
+// currency can't be summed and return ErrCurrencyMismatch.
+func (l *Ledger) Balance(account string) (Money, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total Money
+	for _, tx := range l.transactions {
+		for _, posting := range tx.Postings {
+			if posting.Account == account {
+				var err error
+				if total, err = total.Add(posting.Amount); err != nil {
+					return Money{}, err
+				}
+			}
+		}
+	}
+	return total, nil
+}
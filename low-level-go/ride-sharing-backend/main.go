@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// surgeHalfLife is how fast surge follows supply and demand, in the server
+// and the simulator alike.
+const surgeHalfLife = 2 * time.Minute
+
+// trackingKeyEnv holds the tracking token key when -tracking-key is not set,
+// so the key stays out of the process list.
+const trackingKeyEnv = "RIDE_SHARING_TRACKING_KEY"
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := runSimulate(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	addr := flag.String("addr", ":8080", "address to listen on")
+	rateCardPath := flag.String("rate-card", "", "JSON rate card; standard pricing when empty")
+	eventLogDir := flag.String("event-log", "", "directory for the trip event log; trips kept in memory only when empty")
+	sqlitePath := flag.String("sqlite", "", "SQLite database file for trips, instead of -event-log")
+	roadGraphPath := flag.String("road-graph", "", "JSON road graph for routing; straight-line estimates when empty")
+	serviceAreasPath := flag.String("service-areas", "", "GeoJSON service areas and zones; trips allowed anywhere when empty")
+	taxRate := flag.Float64("tax-rate", 0.08, "tax added to fares")
+	maxSurge := flag.Float64("max-surge", 2.5, "cap on the surge multiplier; 1 turns surge off")
+	trackingKey := flag.String("tracking-key", "", "hex key (32+ bytes) signing rider tracking tokens; defaults to $"+trackingKeyEnv+", random per process when both are empty")
+	flag.Parse()
+
+	// Every component reads time from this one clock.
+	var clock Clock = SystemClock{}
+
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	go dispatcher.WatchHeartbeats(context.Background(), 15*time.Second)
+	var repo TripRepository = NewTripRepositoryMemory()
+	var eventLog *TripRepositoryEventLog
+	switch {
+	case *eventLogDir != "" && *sqlitePath != "":
+		log.Fatal("use either -event-log or -sqlite")
+	case *sqlitePath != "":
+		db, err := sql.Open("sqlite3", *sqlitePath+"?_busy_timeout=5000")
+		if err != nil {
+			log.Fatalf("opening trip database: %v", err)
+		}
+		if repo, err = NewTripRepositorySQLite(db); err != nil {
+			log.Fatalf("opening trip database: %v", err)
+		}
+	case *eventLogDir != "":
+		var err error
+		if eventLog, err = OpenTripEventLog(*eventLogDir, 1000, clock, time.Local); err != nil {
+			log.Fatalf("opening event log: %v", err)
+		}
+		repo = eventLog
+	}
+	trips := NewTripService(repo, clock)
+	dispatcher.ReadRequestsFrom(trips.GetTrip)
+
+	router := NewRouter(nil)
+	var pricing PriceCalculator = StandardPricingCalculator{}
+	if *roadGraphPath != "" {
+		graph, err := LoadRoadGraph(*roadGraphPath)
+		if err != nil {
+			log.Fatalf("loading road graph: %v", err)
+		}
+		router = NewRouter(graph)
+		dispatcher.RankByETA(router)
+		pricing = NewStandardPricingCalculator(router, router)
+	}
+	metered := NewMeteredPricingCalculator(standardRates(), pricing)
+	currency := USD
+	if *rateCardPath != "" {
+		rates, err := NewRateCardStore(*rateCardPath)
+		if err != nil {
+			log.Fatalf("loading rate card: %v", err)
+		}
+		go rates.Watch(context.Background(), 30*time.Second)
+		metered.Card = rates
+		currency = rates.Current().Currency
+		metered.Fallback = RuleBasedPricingCalculator{Rates: rates, Distance: router, ETA: router}
+	}
+	pricing = metered
+	var geofence *Geofence
+	if *serviceAreasPath != "" {
+		var err error
+		if geofence, err = LoadGeofence(*serviceAreasPath, currency); err != nil {
+			log.Fatalf("loading service areas: %v", err)
+		}
+		trips.UseGeofence(geofence)
+	}
+	surge := NewSurgePricingCalculator(pricing, dispatcher, clock, *maxSurge, surgeHalfLife)
+	surge.Zones = geofence
+	pricing = surge
+	if geofence != nil {
+		pricing = ZonePricingCalculator{Base: pricing, Zones: geofence}
+	}
+	promos := NewPromoEngine(clock)
+	promos.AddCode(PromoCode{Code: "FIRSTRIDE", Kind: PromoFirstRideFree, MaxDiscount: MustParseMoney("15.00", currency)})
+	pricing = PromoPricingCalculator{Base: pricing, Promos: promos}
+	trips.OnBeforeTransition(promos.ReserveRedemption)
+	trips.OnAbortedTransition(promos.ReleaseRedemption)
+	trips.OnAfterTransition(promos.TripCompleted)
+	pricing = TaxPricingCalculator{Base: pricing, Label: "Sales tax", Rate: *taxRate}
+
+	match := func(tripID string) error {
+		_, err := trips.AssignNearestDriver(tripID, dispatcher, defaultMatchCandidates, defaultMatchRadiusMiles)
+		return err
+	}
+	scheduler := NewScheduler(trips, match, LogNotifier{}, clock, DefaultSchedulerConfig())
+	go scheduler.Run(context.Background(), 5*time.Second)
+
+	ratings := NewRatingService(trips, clock, 30*24*time.Hour, 7*24*time.Hour)
+	dispatcher.AvoidPairs(ratings.Avoid)
+
+	settlement := NewSettlementService(trips, NewFakeGateway(), NewLedger(), clock, DefaultSettlementConfig())
+	go func() {
+		for range time.Tick(time.Minute) {
+			settlement.RetryPending()
+		}
+	}()
+
+	tracker := NewTripTracker(router, dispatcher, clock)
+	trips.OnTripSaved(tracker.TripSaved)
+	anomalies := NewAnomalyDetector(trips, clock, DefaultAnomalyConfig())
+	trips.OnTripSaved(anomalies.TripSaved)
+
+	offers := NewOfferManager(trips, dispatcher, LogOfferSender{}, LogNotifier{}, clock, DefaultOfferConfig())
+
+	trackingAuth, err := loadTrackingAuth(*trackingKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := NewServer(ServerDeps{
+		Trips:        trips,
+		Dispatcher:   dispatcher,
+		Scheduler:    scheduler,
+		Offers:       offers,
+		Ratings:      ratings,
+		Settlement:   settlement,
+		Promos:       promos,
+		Geofence:     geofence,
+		Router:       router,
+		EventLog:     eventLog,
+		Tracker:      tracker,
+		TrackingAuth: trackingAuth,
+		Anomalies:    anomalies,
+		Pricing:      pricing,
+		Clock:        clock,
+		Cancellation: DefaultCancellationPolicy(currency),
+	})
+
+	log.Printf("ride-sharing API listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server.Routes()))
+}
+
+// loadTrackingAuth uses the key from the flag, else from the environment.
+// Without either, tokens are signed with a random key and die with the
+// process, which is fine for a single dev instance only.
+func loadTrackingAuth(flagKey string) (TrackingAuth, error) {
+	key := flagKey
+	if key == "" {
+		key = os.Getenv(trackingKeyEnv)
+	}
+	if key == "" {
+		log.Printf("warning: no -tracking-key or $%s; tracking tokens will not survive a restart or work across instances", trackingKeyEnv)
+		return RandomTrackingAuth(), nil
+	}
+	return NewTrackingAuth(key)
+}
+
+// runSimulate runs the same synthetic day once per pricing strategy and
+// prints the metrics side by side, e.g.
+//
+//	ride-sharing simulate -strategies standard,surge -drivers 30
+func runSimulate(args []string, out io.Writer) error {
+	config := DefaultSimConfig()
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.Int64Var(&config.Seed, "seed", config.Seed, "random seed for riders and drivers")
+	flags.DurationVar(&config.Duration, "duration", config.Duration, "simulated time")
+	flags.IntVar(&config.Drivers, "drivers", config.Drivers, "drivers, each working one shift")
+	flags.Float64Var(&config.Elasticity, "elasticity", config.Elasticity, "rider price sensitivity")
+	demand := flags.Float64("demand", 1, "scale of the default hourly demand curve")
+	strategies := flags.String("strategies", "standard,surge", "comma-separated pricing strategies: standard, surge, rate-card")
+	rateCardPath := flags.String("rate-card", "rate-card.json", "rate card for the rate-card strategy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	for hour := range config.DemandCurve {
+		config.DemandCurve[hour] *= *demand
+	}
+
+	factories := map[string]PricingFactory{
+		"standard": func(MarketStats, Clock) PriceCalculator { return StandardPricingCalculator{} },
+		"surge": func(market MarketStats, clock Clock) PriceCalculator {
+			return NewSurgePricingCalculator(StandardPricingCalculator{}, market, clock, 2.5, surgeHalfLife)
+		},
+	}
+	if *rateCardPath != "" && strings.Contains(*strategies, "rate-card") {
+		rates, err := NewRateCardStore(*rateCardPath)
+		if err != nil {
+			return fmt.Errorf("loading rate card: %w", err)
+		}
+		if currency := rates.Current().Currency; currency != config.Currency {
+			return fmt.Errorf("rate card prices in %s, but the other strategies and the report use %s", currency, config.Currency)
+		}
+		factories["rate-card"] = func(MarketStats, Clock) PriceCalculator { return RuleBasedPricingCalculator{Rates: rates} }
+	}
+
+	var reports []SimReport
+	for _, name := range strings.Split(*strategies, ",") {
+		factory, ok := factories[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown pricing strategy %q", name)
+		}
+		reports = append(reports, NewSimulation(config, strings.TrimSpace(name), factory).Run())
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "strategy\trequests\tdeclined\tcompleted\tcancel rate\twait mean\twait p90\tutilization\trevenue\tper trip\t")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f%%\t%s\t%s\t%.1f%%\t%s\t%s\t\n",
+			r.Strategy, r.Requests, r.Declined, r.Completed, r.CancellationRate()*100,
+			r.WaitMean, r.WaitP90, r.Utilization*100, r.Revenue, r.RevenuePerTrip())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,45 @@
+package main
+
+// MeteredPricingCalculator bills the distance actually driven, measured from
+// the trip's GPS trace, and the real minutes between StartTrip and
+// CompleteTrip, less the billable wait at stops, which is charged at the
+// wait rate. Trips without a usable trace are priced by Fallback.
+//
+// With a Card, the rates are the trip's class rates from the current rate
+// card, and the card's time windows, minimum fare, airport surcharge and
+// booking fee apply as they do in RuleBasedPricingCalculator.
+type MeteredPricingCalculator struct {
+	Rates    ClassRates
+	Card     *RateCardStore
+	Filter   RouteFilter
+	Fallback PriceCalculator
+}
+
+func NewMeteredPricingCalculator(rates ClassRates, fallback PriceCalculator) MeteredPricingCalculator {
+	return MeteredPricingCalculator{
+		Rates:    rates,
+		Filter:   DefaultRouteFilter(),
+		Fallback: fallback,
+	}
+}
+
+func (c MeteredPricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	if len(c.Filter.Clean(trip.Route)) < 2 || trip.StartedAt.IsZero() || trip.CompletedAt.IsZero() {
+		return c.Fallback.CalculateFare(trip)
+	}
+
+	distance := c.Filter.Length(trip.Route)
+	minutes := trip.CompletedAt.Sub(trip.StartedAt).Minutes() - trip.StopWait(trip.CompletedAt).Minutes()
+
+	if c.Card != nil {
+		card := c.Card.Current()
+		rates := card.ratesFor(trip.VehicleClass)
+		fare := meterFare(rates, distance, DistanceFromGPS, minutes)
+		card.adjust(&fare, rates, trip)
+		return fare
+	}
+	fare := meterFare(c.Rates, distance, DistanceFromGPS, minutes)
+	fare.AtLeast("Minimum fare", c.Rates.MinimumFare)
+	addStopWait(&fare, c.Rates, trip)
+	return fare
+}
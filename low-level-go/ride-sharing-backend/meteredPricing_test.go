@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeteredPricingBillsTheDrivenRoute(t *testing.T) {
+	start := time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+	pickup := &Location{Latitude: 37.7749, Longitude: -122.4194}
+	dropoff := &Location{Latitude: 37.7849, Longitude: -122.4194}
+	trip := NewTrip("t1", &User{ID: "r1"}, pickup, dropoff, testNow)
+	trip.State = InProgress
+	trip.StartedAt = start
+	// A detour east and back: about twice the straight-line distance.
+	for i, loc := range []*Location{
+		pickup,
+		{Latitude: 37.7749, Longitude: -122.4094},
+		{Latitude: 37.7849, Longitude: -122.4094},
+		dropoff,
+	} {
+		if err := trip.RecordLocation(loc, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trip.CompletedAt = start.Add(10 * time.Minute)
+
+	calculator := NewMeteredPricingCalculator(standardRates(), StandardPricingCalculator{})
+	metered := calculator.CalculateFare(trip).Sum(FareDistance)
+	straight := StandardPricingCalculator{}.CalculateFare(trip).Sum(FareDistance)
+	if c, _ := metered.Cmp(straight.Mul(2)); c <= 0 {
+		t.Errorf("metered distance charge %s, want more than twice the straight-line %s", metered, straight)
+	}
+
+	trip.Route = nil
+	fallback := calculator.CalculateFare(trip).Total()
+	if c, _ := fallback.Cmp(StandardPricingCalculator{}.CalculateFare(trip).Total()); c != 0 {
+		t.Errorf("without pings got %s, want the fallback fare", fallback)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	INR Currency = "INR"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+)
+
+type currencyInfo struct {
+	decimals int
+	symbol   string
+}
+
+var currencies = map[Currency]currencyInfo{
+	USD: {decimals: 2, symbol: "$"},
+	EUR: {decimals: 2, symbol: "€"},
+	GBP: {decimals: 2, symbol: "£"},
+	INR: {decimals: 2, symbol: "₹"},
+	JPY: {decimals: 0, symbol: "¥"},
+	KWD: {decimals: 3},
+}
+
+// Decimals is the number of minor-unit digits, e.g. 2 for USD and 0 for JPY.
+// Unknown currencies default to 2.
+func (c Currency) Decimals() int {
+	if info, ok := currencies[c]; ok {
+		return info.decimals
+	}
+	return 2
+}
+
+func (c Currency) minorPerMajor() int64 {
+	return int64(math.Pow10(c.Decimals()))
+}
+
+type ErrCurrencyMismatch struct {
+	A Currency
+	B Currency
+}
+
+func (e ErrCurrencyMismatch) Error() string {
+	return fmt.Sprintf("currency mismatch: %s and %s", e.A, e.B)
+}
+
+// Money is an exact amount in the currency's minor units (cents for USD).
+// The zero value has no currency and acts as zero in any currency, so an
+// unset fare can be added to without a mismatch.
+type Money struct {
+	Amount   int64
+	Currency Currency
+}
+
+func NewMoney(minor int64, currency Currency) Money {
+	return Money{Amount: minor, Currency: currency}
+}
+
+// ParseMoney reads a decimal amount in major units ("12.35"). Extra digits
+// beyond the currency's precision are rounded half to even.
+func ParseMoney(s string, currency Currency) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if digits == "" || digits == "." {
+		return Money{}, fmt.Errorf("parse money %q: no amount", s)
+	}
+	if strings.ContainsAny(digits[:1], "+-") {
+		return Money{}, fmt.Errorf("parse money %q: more than one sign", s)
+	}
+	s = digits
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	decimals := currency.Decimals()
+	for len(frac) < decimals {
+		frac += "0"
+	}
+	kept, dropped := frac[:decimals], frac[decimals:]
+
+	minor, err := strconv.ParseInt(whole+kept, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("parse money %q: %w", s, err)
+	}
+	if dropped != "" {
+		if _, err := strconv.ParseUint(dropped, 10, 64); err != nil {
+			return Money{}, fmt.Errorf("parse money %q: %w", s, err)
+		}
+		half := "5" + strings.Repeat("0", len(dropped)-1)
+		switch {
+		case dropped > half:
+			minor++
+		case dropped == half && minor%2 == 1:
+			minor++
+		}
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// MustParseMoney is ParseMoney for amounts fixed in code.
+func MustParseMoney(s string, currency Currency) Money {
+	m, err := ParseMoney(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m Money) unifyWith(o Money) (Currency, error) {
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount == 0:
+		return o.Currency, nil
+	case o.Currency == "" && o.Amount == 0:
+		return m.Currency, nil
+	}
+	return "", ErrCurrencyMismatch{A: m.Currency, B: o.Currency}
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.unifyWith(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than o.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := m.unifyWith(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Mul scales the amount, e.g. a per-mile rate by miles driven, rounding the
+// result to the nearest minor unit with banker's rounding.
+func (m Money) Mul(factor float64) Money {
+	return Money{Amount: int64(math.RoundToEven(float64(m.Amount) * factor)), Currency: m.Currency}
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsPositive() bool { return m.Amount > 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// Float64 returns the amount in major units. Use it for ratios and display,
+// never to do arithmetic that is stored again.
+func (m Money) Float64() float64 {
+	return float64(m.Amount) / float64(m.Currency.minorPerMajor())
+}
+
+// Decimal renders the amount in major units without grouping, e.g. "1234.50".
+func (m Money) Decimal() string {
+	decimals := m.Currency.Decimals()
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	per := m.Currency.minorPerMajor()
+	if decimals == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/per, decimals, amount%per)
+}
+
+// String formats for receipts: "$1,234.50", "¥1,235", "KWD 1.235".
+func (m Money) String() string {
+	decimal := strings.TrimPrefix(m.Decimal(), "-")
+	whole, frac, hasFrac := strings.Cut(decimal, ".")
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFrac {
+		grouped.WriteString("." + frac)
+	}
+
+	sign := ""
+	if m.IsNegative() {
+		sign = "-"
+	}
+	if info, ok := currencies[m.Currency]; ok && info.symbol != "" {
+		return sign + info.symbol + grouped.String()
+	}
+	if m.Currency == "" {
+		return sign + grouped.String()
+	}
+	return sign + string(m.Currency) + " " + grouped.String()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseMoneyRejectsEmptyAmounts(t *testing.T) {
+	for _, s := range []string{"", " ", "-", "+", "."} {
+		if m, err := ParseMoney(s, USD); err == nil {
+			t.Errorf("ParseMoney(%q) = %v, want an error", s, m)
+		}
+	}
+	if m, err := ParseMoney("-.5", USD); err != nil || m != NewMoney(-50, USD) {
+		t.Errorf("ParseMoney(-.5) = %v, %v", m, err)
+	}
+}
+
+func TestFareBreakdownReportsMixedCurrencies(t *testing.T) {
+	var fare FareBreakdown
+	fare.Add(FareBase, "Base fare", NewMoney(300, EUR))
+	fare.Add(FareSurcharge, "Airport zone", NewMoney(500, USD))
+	fare.AtLeast("Minimum fare", NewMoney(700, USD))
+	fare.Scale(FareSurcharge, "Surge", 2)
+
+	var mismatch ErrCurrencyMismatch
+	if !errors.As(fare.Err(), &mismatch) {
+		t.Fatalf("Err() = %v, want ErrCurrencyMismatch", fare.Err())
+	}
+	if got := fare.Total(); got != NewMoney(600, EUR) {
+		t.Errorf("total = %s, want only the EUR lines, surged", got)
+	}
+}
+
+func TestLedgerRejectsUnbalancedTransactions(t *testing.T) {
+	ledger := NewLedger()
+	_, err := ledger.Post(LedgerTransaction{TripID: "t1", Postings: []Posting{
+		{Account: riderAccount("r1"), Amount: NewMoney(1000, USD)},
+		{Account: driverAccount("d1"), Amount: NewMoney(-900, USD)},
+	}})
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Fatalf("got %v, want ErrUnbalanced", err)
+	}
+	if balance, err := ledger.Balance(riderAccount("r1")); err != nil || !balance.IsZero() {
+		t.Errorf("rejected transaction was posted: %s, %v", balance, err)
+	}
+}
+
+func TestGeofenceRejectsSurchargesInAnotherCurrency(t *testing.T) {
+	zone := func(currency string) string {
+		return `{"type": "FeatureCollection", "features": [{"type": "Feature",
+			"properties": {"name": "Airport", "kind": "AIRPORT", "surcharge": "5.00"` + currency + `},
+			"geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}]}`
+	}
+	if _, err := ParseGeofence([]byte(zone(`, "currency": "USD"`)), EUR); err == nil || !strings.Contains(err.Error(), "fares are in EUR") {
+		t.Errorf("USD surcharge with EUR fares: got %v", err)
+	}
+	geofence, err := ParseGeofence([]byte(zone("")), EUR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := geofence.Zones[0].Surcharge; got != NewMoney(500, EUR) {
+		t.Errorf("surcharge without a currency = %v, want it in the fare currency", got)
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency Currency
+		want     int64
+	}{
+		{"12.35", USD, 1235},
+		{"12.3", USD, 1230},
+		{"12", USD, 1200},
+		{"+12.35", USD, 1235},
+		{" 7.50 ", EUR, 750},
+		// Digits past the currency's precision round half to even.
+		{"12.345", USD, 1234},
+		{"12.355", USD, 1236},
+		{"12.3451", USD, 1235},
+		{"12.3449999", USD, 1234},
+		{"1234.5", JPY, 1234},
+		{"1235.5", JPY, 1236},
+		{"1.2345", KWD, 1234},
+		{"1.2355", KWD, 1236},
+		// Negatives round the same way as their magnitude.
+		{"-12.345", USD, -1234},
+		{"-12.355", USD, -1236},
+		{"-0.005", USD, 0},
+		{"-1234.5", JPY, -1234},
+	}
+	for _, tc := range tests {
+		got, err := ParseMoney(tc.in, tc.currency)
+		if err != nil {
+			t.Errorf("ParseMoney(%q, %s): %v", tc.in, tc.currency, err)
+			continue
+		}
+		if want := NewMoney(tc.want, tc.currency); got != want {
+			t.Errorf("ParseMoney(%q, %s) = %d, want %d", tc.in, tc.currency, got.Amount, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"--5", "+-5", "-+-5", "1.2.3", "12,50", "1e3", "5$", "1.0x"} {
+		if m, err := ParseMoney(bad, USD); err == nil {
+			t.Errorf("ParseMoney(%q) = %v, want an error", bad, m)
+		}
+	}
+}
+
+func TestMoneyMulRoundsHalfToEven(t *testing.T) {
+	tests := []struct {
+		amount int64
+		factor float64
+		want   int64
+	}{
+		{25, 0.5, 12},
+		{35, 0.5, 18},
+		{-25, 0.5, -12},
+		{-35, 0.5, -18},
+		{10, 0.25, 2},
+		{30, 0.25, 8},
+		{150, 1.5, 225},
+		{1000, 0, 0},
+		{1001, 1, 1001},
+	}
+	for _, tc := range tests {
+		if got := NewMoney(tc.amount, USD).Mul(tc.factor); got != NewMoney(tc.want, USD) {
+			t.Errorf("%d × %v = %d, want %d", tc.amount, tc.factor, got.Amount, tc.want)
+		}
+	}
+	if got := NewMoney(5, JPY).Mul(0.5); got != NewMoney(2, JPY) {
+		t.Errorf("¥5 × 0.5 = %s, want ¥2", got)
+	}
+}
+
+func TestMoneyFormatsPerCurrency(t *testing.T) {
+	tests := []struct {
+		money   Money
+		decimal string
+		display string
+	}{
+		{NewMoney(123450, USD), "1234.50", "$1,234.50"},
+		{NewMoney(-5, USD), "-0.05", "-$0.05"},
+		{NewMoney(99, EUR), "0.99", "€0.99"},
+		{NewMoney(100000000, GBP), "1000000.00", "£1,000,000.00"},
+		{NewMoney(1234, JPY), "1234", "¥1,234"},
+		{NewMoney(-1234567, JPY), "-1234567", "-¥1,234,567"},
+		{NewMoney(1235, KWD), "1.235", "KWD 1.235"},
+		{NewMoney(1250, "CHF"), "12.50", "CHF 12.50"},
+		{NewMoney(1250, ""), "12.50", "12.50"},
+	}
+	for _, tc := range tests {
+		if got := tc.money.Decimal(); got != tc.decimal {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tc.money.Amount, tc.money.Currency, got, tc.decimal)
+		}
+		if got := tc.money.String(); got != tc.display {
+			t.Errorf("%d %s: String() = %q, want %q", tc.money.Amount, tc.money.Currency, got, tc.display)
+		}
+	}
+
+	for currency, decimals := range map[Currency]int{USD: 2, JPY: 0, KWD: 3, "CHF": 2} {
+		if got := currency.Decimals(); got != decimals {
+			t.Errorf("%s has %d decimals, want %d", currency, got, decimals)
+		}
+	}
+}
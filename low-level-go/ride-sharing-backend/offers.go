@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOfferNotFound = errors.New("offer not found")
+	ErrOfferClosed   = errors.New("offer is no longer open")
+	ErrDispatching   = errors.New("trip is already being dispatched")
+)
+
+type OfferStatus string
+
+const (
+	OfferPending  OfferStatus = "PENDING"
+	OfferAccepted OfferStatus = "ACCEPTED"
+	OfferDeclined OfferStatus = "DECLINED"
+	OfferExpired  OfferStatus = "EXPIRED"
+)
+
+type Offer struct {
+	ID        string
+	TripID    string
+	DriverID  string
+	Attempt   int
+	ExpiresAt time.Time
+	Status    OfferStatus
+}
+
+// OfferSender pushes an offer to the driver's app.
+type OfferSender interface {
+	SendOffer(offer Offer)
+}
+
+type LogOfferSender struct{}
+
+func (LogOfferSender) SendOffer(offer Offer) {
+	log.Printf("offer %s: trip %s to driver %s, expires %s",
+		offer.ID, offer.TripID, offer.DriverID, offer.ExpiresAt.Format(time.RFC3339))
+}
+
+type OfferConfig struct {
+	// Timeout is how long a driver has to answer.
+	Timeout time.Duration
+	// MaxAttempts caps offers per trip before it is cancelled with "no drivers".
+	MaxAttempts int
+	Candidates  int
+	RadiusMiles float64
+}
+
+func DefaultOfferConfig() OfferConfig {
+	return OfferConfig{
+		Timeout:     15 * time.Second,
+		MaxAttempts: 5,
+		Candidates:  defaultMatchCandidates,
+		RadiusMiles: defaultMatchRadiusMiles,
+	}
+}
+
+type offerEntry struct {
+	offer Offer
+	timer Timer
+}
+
+// OfferManager runs the offer cascade: the trip is offered to the best
+// driver, and if that driver declines or lets the offer expire the trip goes
+// back to Requested and is offered to the next best driver, skipping anyone
+// who already passed on it. After MaxAttempts the trip is cancelled.
+//
+// Each offer resolves exactly once: accept, decline and the expiry timer all
+// race for it under the manager lock and only the first one wins. A trip has
+// at most one cascade; dispatching it again while one runs is rejected.
+// Offer timeouts and retries run on the injected Clock.
+type OfferManager struct {
+	trips      *TripService
+	dispatcher *Dispatcher
+	sender     OfferSender
+	notifier   Notifier
+	clock      Clock
+	config     OfferConfig
+
+	mu       sync.Mutex
+	nextID   int
+	offers   map[string]*offerEntry
+	active   map[string]bool            // trip IDs with a running cascade
+	attempts map[string]int             // trip ID -> offers made
+	passed   map[string]map[string]bool // trip ID -> drivers who declined or timed out
+}
+
+func NewOfferManager(trips *TripService, dispatcher *Dispatcher, sender OfferSender, notifier Notifier, clock Clock, config OfferConfig) *OfferManager {
+	return &OfferManager{
+		trips:      trips,
+		dispatcher: dispatcher,
+		sender:     sender,
+		notifier:   notifier,
+		clock:      clock,
+		config:     config,
+		offers:     make(map[string]*offerEntry),
+		active:     make(map[string]bool),
+		attempts:   make(map[string]int),
+		passed:     make(map[string]map[string]bool),
+	}
+}
+
+// Dispatch starts the cascade for a requested trip, or returns
+// ErrDispatching if one is already running.
+func (m *OfferManager) Dispatch(tripID string) error {
+	trip, err := m.trips.GetTrip(tripID)
+	if err != nil {
+		return err
+	}
+	if trip.State != Requested {
+		return ErrInvalidTransition{From: trip.State, To: Offered}
+	}
+
+	m.mu.Lock()
+	if m.active[tripID] {
+		m.mu.Unlock()
+		return ErrDispatching
+	}
+	m.active[tripID] = true
+	m.mu.Unlock()
+
+	m.offerNext(tripID)
+	return nil
+}
+
+// Accept assigns the trip to the driver holding the offer.
+func (m *OfferManager) Accept(offerID, driverID string) (*Trip, error) {
+	offer, err := m.close(offerID, driverID, OfferAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	trip, err := m.trips.AssignReservedDriver(offer.TripID, driverID, m.dispatcher)
+	if err == nil {
+		m.forget(offer.TripID)
+		return trip, nil
+	}
+
+	// The driver went offline or the trip was cancelled while the offer was
+	// open: treat it like a decline so the trip keeps moving.
+	m.pass(offer)
+	return nil, err
+}
+
+func (m *OfferManager) Decline(offerID, driverID string) error {
+	offer, err := m.close(offerID, driverID, OfferDeclined)
+	if err != nil {
+		return err
+	}
+	m.pass(offer)
+	return nil
+}
+
+func (m *OfferManager) Offer(offerID string) (Offer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.offers[offerID]
+	if !ok {
+		return Offer{}, ErrOfferNotFound
+	}
+	return entry.offer, nil
+}
+
+func (m *OfferManager) expire(offerID, driverID string) {
+	offer, err := m.close(offerID, driverID, OfferExpired)
+	if err != nil {
+		return
+	}
+	m.pass(offer)
+}
+
+// close resolves a pending offer. Only the first caller succeeds.
+func (m *OfferManager) close(offerID, driverID string, status OfferStatus) (Offer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.offers[offerID]
+	if !ok || entry.offer.DriverID != driverID {
+		return Offer{}, ErrOfferNotFound
+	}
+	if entry.offer.Status != OfferPending {
+		return Offer{}, ErrOfferClosed
+	}
+	entry.offer.Status = status
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	return entry.offer, nil
+}
+
+// pass hands the driver back to the dispatcher, returns the trip to
+// Requested and moves on to the next candidate.
+func (m *OfferManager) pass(offer Offer) {
+	m.dispatcher.CancelReservation(offer.DriverID)
+
+	m.mu.Lock()
+	if m.passed[offer.TripID] == nil {
+		m.passed[offer.TripID] = make(map[string]bool)
+	}
+	m.passed[offer.TripID][offer.DriverID] = true
+	m.mu.Unlock()
+
+	if _, err := m.trips.WithdrawOffer(offer.TripID); err != nil {
+		// Cancelled by the rider in the meantime; nothing left to dispatch.
+		m.forget(offer.TripID)
+		return
+	}
+	m.offerNext(offer.TripID)
+}
+
+func (m *OfferManager) offerNext(tripID string) {
+	m.mu.Lock()
+	m.attempts[tripID]++
+	attempt := m.attempts[tripID]
+	exclude := make(map[string]bool, len(m.passed[tripID]))
+	for id := range m.passed[tripID] {
+		exclude[id] = true
+	}
+	m.mu.Unlock()
+
+	if attempt > m.config.MaxAttempts {
+		m.giveUp(tripID)
+		return
+	}
+
+	trip, err := m.trips.GetTrip(tripID)
+	if err != nil || trip.State != Requested {
+		m.forget(tripID)
+		return
+	}
+	driver, err := m.dispatcher.Reserve(trip, exclude, m.config.Candidates, m.config.RadiusMiles)
+	if err != nil {
+		// Nobody to ask right now; this still costs an attempt.
+		m.clock.AfterFunc(m.config.Timeout, func() { m.offerNext(tripID) })
+		return
+	}
+	if _, err := m.trips.OfferToDriver(tripID); err != nil {
+		m.dispatcher.CancelReservation(driver.ID)
+		m.forget(tripID)
+		return
+	}
+
+	m.mu.Lock()
+	m.nextID++
+	offer := Offer{
+		ID:        fmt.Sprintf("offer-%d", m.nextID),
+		TripID:    tripID,
+		DriverID:  driver.ID,
+		Attempt:   attempt,
+		ExpiresAt: m.clock.Now().Add(m.config.Timeout),
+		Status:    OfferPending,
+	}
+	entry := &offerEntry{offer: offer}
+	m.offers[offer.ID] = entry
+	entry.timer = m.clock.AfterFunc(m.config.Timeout, func() { m.expire(offer.ID, offer.DriverID) })
+	m.mu.Unlock()
+
+	m.sender.SendOffer(offer)
+}
+
+func (m *OfferManager) giveUp(tripID string) {
+	m.forget(tripID)
+	m.dispatcher.DropRequest(tripID)
+	trip, err := m.trips.CancelTrip(tripID, CancelledBySystem, "no drivers", nil)
+	if err != nil {
+		return
+	}
+	m.notifier.Notify(trip, "no drivers accepted, trip cancelled")
+}
+
+// forget drops the cascade bookkeeping for a trip that no longer needs it.
+func (m *OfferManager) forget(tripID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.active, tripID)
+	delete(m.attempts, tripID)
+	delete(m.passed, tripID)
+	for id, entry := range m.offers {
+		if entry.offer.TripID == tripID && entry.offer.Status != OfferPending {
+			delete(m.offers, id)
+		}
+	}
+}
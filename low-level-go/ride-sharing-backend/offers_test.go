@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSender struct {
+	mu     sync.Mutex
+	offers []Offer
+}
+
+func (s *recordingSender) SendOffer(offer Offer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offers = append(s.offers, offer)
+}
+
+func TestOfferCascadeRunsOnTheClock(t *testing.T) {
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	for _, id := range []string{"d1", "d2"} {
+		dispatcher.GoOnline(&Driver{ID: id, Vehicle: Vehicle{Class: Economy}}, testPickup)
+	}
+	sender := &recordingSender{}
+	config := DefaultOfferConfig()
+	offers := NewOfferManager(trips, dispatcher, sender, &recordingNotifier{}, clock, config)
+
+	if _, err := trips.RequestTrip("t1", &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	if err := offers.Dispatch("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := offers.Dispatch("t1"); !errors.Is(err, ErrDispatching) && !errors.As(err, new(ErrInvalidTransition)) {
+		t.Errorf("second dispatch: got %v, want it rejected", err)
+	}
+
+	clock.Advance(config.Timeout)
+	if len(sender.offers) != 2 || sender.offers[0].Status != OfferPending {
+		t.Fatalf("offers sent %v, want the first to expire into a second", sender.offers)
+	}
+	first, _ := offers.Offer(sender.offers[0].ID)
+	if first.Status != OfferExpired {
+		t.Errorf("first offer is %s, want EXPIRED", first.Status)
+	}
+	second := sender.offers[1]
+	if second.DriverID == first.DriverID {
+		t.Errorf("the cascade re-offered the trip to %s", second.DriverID)
+	}
+
+	trip, err := offers.Accept(second.ID, second.DriverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trip.State != DriverAssigned || trip.Driver.ID != second.DriverID {
+		t.Errorf("accepted trip is %s with %+v", trip.State, trip.Driver)
+	}
+	clock.Advance(config.Timeout)
+	if len(sender.offers) != 2 {
+		t.Errorf("an accepted offer still expired into %d offers", len(sender.offers))
+	}
+}
+
+// While a cascade waits to retry, the trip is REQUESTED again; a second
+// Dispatch must not start a parallel cascade or reset the first.
+func TestDuplicateDispatchKeepsTheCascade(t *testing.T) {
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	config := DefaultOfferConfig()
+	offers := NewOfferManager(trips, dispatcher, &recordingSender{}, &recordingNotifier{}, clock, config)
+
+	if _, err := trips.RequestTrip("t1", &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	if err := offers.Dispatch("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := offers.Dispatch("t1"); !errors.Is(err, ErrDispatching) {
+		t.Fatalf("second dispatch: got %v, want ErrDispatching", err)
+	}
+
+	clock.Advance(time.Duration(config.MaxAttempts) * config.Timeout)
+	trip, _ := trips.GetTrip("t1")
+	if trip.State != Cancelled || trip.Cancellation.Reason != "no drivers" {
+		t.Errorf("trip is %s, want cancelled after %d attempts", trip.State, config.MaxAttempts)
+	}
+}
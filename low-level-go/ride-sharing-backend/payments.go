@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNothingToCharge = errors.New("trip has no fare to charge")
+	ErrAlreadySettled  = errors.New("trip payment already settled")
+	ErrPaymentFailed   = errors.New("payment failed")
+)
+
+// PaymentStatus is the money side of a finished trip, tracked next to its
+// TripState: a Completed trip can still be PaymentPending.
+type PaymentStatus string
+
+const (
+	PaymentNone    PaymentStatus = ""
+	PaymentPending PaymentStatus = "PAYMENT_PENDING"
+	PaymentSettled PaymentStatus = "PAYMENT_SETTLED"
+)
+
+// PaymentGateway charges a rider. Charges with the same idempotency key
+// must be applied at most once.
+type PaymentGateway interface {
+	Charge(riderID string, amount Money, idempotencyKey string) (chargeID string, err error)
+}
+
+// FakeGateway is an in-memory PaymentGateway for tests and local runs.
+// Riders listed in Declined, or the next FailNext charges, are refused.
+type FakeGateway struct {
+	mu       sync.Mutex
+	Declined map[string]bool
+	FailNext int
+	charges  map[string]string // idempotency key -> charge ID
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		Declined: make(map[string]bool),
+		charges:  make(map[string]string),
+	}
+}
+
+func (g *FakeGateway) Charge(riderID string, amount Money, idempotencyKey string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if chargeID, ok := g.charges[idempotencyKey]; ok {
+		return chargeID, nil
+	}
+	if g.FailNext > 0 {
+		g.FailNext--
+		return "", errors.New("gateway unavailable")
+	}
+	if g.Declined[riderID] {
+		return "", errors.New("card declined")
+	}
+	chargeID := fmt.Sprintf("ch_%d", len(g.charges)+1)
+	g.charges[idempotencyKey] = chargeID
+	return chargeID, nil
+}
+
+func (t *Trip) MarkPaymentPending() error {
+	if t.Payment == PaymentSettled {
+		return ErrAlreadySettled
+	}
+	t.Payment = PaymentPending
+	return nil
+}
+
+func (t *Trip) MarkPaymentSettled(chargeID string) error {
+	if t.Payment == PaymentSettled {
+		return ErrAlreadySettled
+	}
+	t.Payment = PaymentSettled
+	t.ChargeID = chargeID
+	return nil
+}
+
+type SettlementConfig struct {
+	CommissionRate float64 // platform share of the pre-tax fare
+}
+
+func DefaultSettlementConfig() SettlementConfig {
+	return SettlementConfig{CommissionRate: 0.25}
+}
+
+// SettlementService charges riders for finished trips and books the money
+// in the ledger. A failed charge leaves the trip PaymentPending, and
+// RetryPending tries again.
+//
+// A trip held for fraud review is charged like any other, but the driver's
+// share is booked to a holding account and only moved to the driver once a
+// reviewer clears the trip.
+type SettlementService struct {
+	trips   *TripService
+	gateway PaymentGateway
+	ledger  *Ledger
+	clock   Clock
+	config  SettlementConfig
+
+	mu      sync.Mutex
+	pending map[string]bool    // trip IDs whose charge failed
+	held    map[string]Posting // trip ID -> driver payout waiting for review
+}
+
+func NewSettlementService(trips *TripService, gateway PaymentGateway, ledger *Ledger, clock Clock, config SettlementConfig) *SettlementService {
+	return &SettlementService{
+		trips:   trips,
+		gateway: gateway,
+		ledger:  ledger,
+		clock:   clock,
+		config:  config,
+		pending: make(map[string]bool),
+		held:    make(map[string]Posting),
+	}
+}
+
+// Settle charges the trip fare. The trip ID is the idempotency key, so a
+// retry after a timeout can't charge the rider twice, and only the caller
+// whose save marks the trip settled posts it to the ledger.
+//
+// A HELD trip is charged but its driver payout is withheld; settling it again
+// after a reviewer clears it releases the payout. A REJECTED trip that was
+// not charged yet is never charged.
+func (s *SettlementService) Settle(tripID string) (*Trip, error) {
+	trip, err := s.trips.GetTrip(tripID)
+	if err != nil {
+		return nil, err
+	}
+	if trip.Payment == PaymentSettled {
+		if trip.Review != nil && trip.Review.Status == ReviewCleared {
+			if err := s.releasePayout(trip); err != nil {
+				return nil, err
+			}
+		}
+		return trip, nil
+	}
+	if trip.Review != nil && trip.Review.Status == ReviewRejected {
+		return nil, ErrTripRejected
+	}
+	if !trip.Fare.IsPositive() || !trip.State.IsTerminal() || trip.Rider == nil {
+		return nil, ErrNothingToCharge
+	}
+	// Split the fare before charging, so a fare that can't be posted is
+	// never charged.
+	hold := trip.Review != nil && trip.Review.Status == ReviewHeld
+	entries, err := s.settlementEntries(trip, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	chargeID, chargeErr := s.gateway.Charge(trip.Rider.ID, trip.Fare, trip.ID)
+	if chargeErr != nil {
+		if _, err := s.trips.update(tripID, (*Trip).MarkPaymentPending); err != nil && !errors.Is(err, ErrAlreadySettled) {
+			return nil, err
+		}
+		s.setPending(tripID, true)
+		return nil, fmt.Errorf("%w: trip %s: %v", ErrPaymentFailed, tripID, chargeErr)
+	}
+
+	trip, err = s.trips.update(tripID, func(t *Trip) error {
+		return t.MarkPaymentSettled(chargeID)
+	})
+	if err == nil || errors.Is(err, ErrAlreadySettled) {
+		s.setPending(tripID, false)
+	}
+	if errors.Is(err, ErrAlreadySettled) {
+		return s.trips.GetTrip(tripID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries.Memo = "trip settlement " + chargeID
+	if _, err := s.ledger.Post(entries); err != nil {
+		return nil, err
+	}
+	if hold && trip.Driver != nil {
+		s.withholdPayout(trip, entries)
+		// A reviewer may have cleared the trip while it was being charged.
+		if latest, err := s.trips.GetTrip(tripID); err == nil && latest.Review.Status == ReviewCleared {
+			if err := s.releasePayout(latest); err != nil {
+				return nil, err
+			}
+			return latest, nil
+		}
+	}
+	return trip, nil
+}
+
+// withholdPayout remembers the driver's share booked to the holding account
+// until the trip is cleared.
+func (s *SettlementService) withholdPayout(trip *Trip, entries LedgerTransaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, posting := range entries.Postings {
+		if posting.Account == accountPayoutsHeld {
+			s.held[trip.ID] = Posting{Account: driverAccount(trip.Driver.ID), Amount: posting.Amount}
+		}
+	}
+}
+
+// releasePayout moves a cleared trip's withheld driver share from the
+// holding account to the driver, once. A rejected trip's share stays in the
+// holding account.
+func (s *SettlementService) releasePayout(trip *Trip) error {
+	s.mu.Lock()
+	payout, ok := s.held[trip.ID]
+	delete(s.held, trip.ID)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	_, err := s.ledger.Post(LedgerTransaction{
+		TripID: trip.ID,
+		At:     s.clock.Now(),
+		Memo:   "payout released after review " + trip.ChargeID,
+		Postings: []Posting{
+			{Account: accountPayoutsHeld, Amount: payout.Amount.Neg()},
+			payout,
+		},
+	})
+	return err
+}
+
+// RetryPending settles every trip still waiting for payment and returns
+// the ones that failed again.
+func (s *SettlementService) RetryPending() map[string]error {
+	s.mu.Lock()
+	var ids []string
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	failed := make(map[string]error)
+	for _, id := range ids {
+		if _, err := s.Settle(id); err != nil {
+			failed[id] = err
+		}
+	}
+	return failed
+}
+
+func (s *SettlementService) setPending(tripID string, pending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pending {
+		s.pending[tripID] = true
+	} else {
+		delete(s.pending, tripID)
+	}
+}
+
+// settlementEntries splits the fare: the tax lines of the breakdown first,
+// then the platform's commission on the rest, and whatever remains is the
+// driver's, last. With holdPayout the driver's share goes to the holding
+// account instead. The memo names the charge, so Settle fills it in once the
+// rider is charged.
+func (s *SettlementService) settlementEntries(trip *Trip, holdPayout bool) (LedgerTransaction, error) {
+	fare := trip.Fare
+	tax := FareBreakdown{Lines: trip.FareBreakdown}.Sum(FareTax)
+	net, err := fare.Sub(tax)
+	if err != nil {
+		return LedgerTransaction{}, err
+	}
+	commission := net.Mul(s.config.CommissionRate)
+	earnings, err := net.Sub(commission)
+	if err != nil {
+		return LedgerTransaction{}, err
+	}
+
+	postings := []Posting{
+		{Account: riderAccount(trip.Rider.ID), Amount: fare},
+		{Account: accountTaxPayable, Amount: tax.Neg()},
+	}
+	if trip.Driver != nil {
+		payee := driverAccount(trip.Driver.ID)
+		if holdPayout {
+			payee = accountPayoutsHeld
+		}
+		postings = append(postings,
+			Posting{Account: accountCommission, Amount: commission.Neg()},
+			Posting{Account: payee, Amount: earnings.Neg()},
+		)
+	} else {
+		// Cancellation fee before a driver was assigned: the platform keeps it.
+		postings = append(postings, Posting{Account: accountCommission, Amount: net.Neg()})
+	}
+
+	return LedgerTransaction{
+		TripID:   trip.ID,
+		At:       s.clock.Now(),
+		Postings: postings,
+	}, nil
+}
+
+// DriverPayoutStatement lists a driver's earnings for one week.
+type DriverPayoutStatement struct {
+	DriverID string
+	From     time.Time
+	To       time.Time
+	Lines    []PayoutLine
+	Total    Money
+}
+
+type PayoutLine struct {
+	TripID string
+	At     time.Time
+	Amount Money
+}
+
+// WeeklyStatement collects the driver's earnings for the week starting at
+// weekStart (inclusive) from the ledger.
+func (s *SettlementService) WeeklyStatement(driverID string, weekStart time.Time) (DriverPayoutStatement, error) {
+	statement := DriverPayoutStatement{
+		DriverID: driverID,
+		From:     weekStart,
+		To:       weekStart.AddDate(0, 0, 7),
+	}
+	account := driverAccount(driverID)
+	for _, tx := range s.ledger.Transactions(statement.From, statement.To) {
+		for _, posting := range tx.Postings {
+			if posting.Account != account {
+				continue
+			}
+			// Driver earnings are credits (negative postings).
+			earned := posting.Amount.Neg()
+			statement.Lines = append(statement.Lines, PayoutLine{TripID: tx.TripID, At: tx.At, Amount: earned})
+			total, err := statement.Total.Add(earned)
+			if err != nil {
+				return DriverPayoutStatement{}, err
+			}
+			statement.Total = total
+		}
+	}
+	return statement, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettleChargesHeldTripAndPaysDriverOnceCleared(t *testing.T) {
+	clock := NewVirtualClock(time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC))
+	repo := NewTripRepositoryMemory()
+	trip := inProgressTrip("t1")
+	trip.Driver = &Driver{ID: "d1"}
+	trip.State = Completed
+	trip.Fare = NewMoney(2000, USD)
+	trip.FareBreakdown = []FareLine{{Kind: FareBase, Amount: NewMoney(2000, USD)}}
+	trip.Flag([]TripFlag{{Kind: AnomalyRepeatedPair, At: clock.Now()}})
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	trips := NewTripService(repo, clock)
+	ledger := NewLedger()
+	settlement := NewSettlementService(trips, NewFakeGateway(), ledger, clock, SettlementConfig{CommissionRate: 0.25})
+	balance := func(account string) Money {
+		t.Helper()
+		amount, err := ledger.Balance(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return amount
+	}
+
+	settled, err := settlement.Settle("t1")
+	if err != nil {
+		t.Fatalf("settling a held trip: %v", err)
+	}
+	if settled.Payment != PaymentSettled {
+		t.Fatalf("payment = %s, want the rider charged while held", settled.Payment)
+	}
+	if got := balance(driverAccount("d1")); !got.IsZero() {
+		t.Errorf("driver paid %s while the trip is held", got.Neg())
+	}
+	if got, want := balance(accountPayoutsHeld), NewMoney(-1500, USD); got != want {
+		t.Errorf("held payouts = %s, want %s", got, want)
+	}
+
+	if _, err := trips.ResolveReview("t1", ReviewCleared, "alice", "", clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := settlement.Settle("t1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := balance(driverAccount("d1")), NewMoney(-1500, USD); got != want {
+		t.Errorf("driver balance after clearing = %s, want %s once", got, want)
+	}
+	if got := balance(accountPayoutsHeld); !got.IsZero() {
+		t.Errorf("held payouts after clearing = %s, want zero", got)
+	}
+}
+
+func TestSettlementMemoNamesTheCharge(t *testing.T) {
+	clock := NewVirtualClock(testNow)
+	repo := NewTripRepositoryMemory()
+	trip := inProgressTrip("t1")
+	trip.Driver = &Driver{ID: "d1"}
+	trip.State = Completed
+	trip.Fare = NewMoney(2000, USD)
+	trip.FareBreakdown = []FareLine{{Kind: FareBase, Amount: NewMoney(2000, USD)}}
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	ledger := NewLedger()
+	settlement := NewSettlementService(NewTripService(repo, clock), NewFakeGateway(), ledger, clock, SettlementConfig{CommissionRate: 0.25})
+
+	settled, err := settlement.Settle("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settled.ChargeID == "" {
+		t.Fatal("settled trip has no charge ID")
+	}
+	transactions := ledger.Transactions(clock.Now(), clock.Now().Add(time.Second))
+	if len(transactions) != 1 {
+		t.Fatalf("%d ledger transactions, want 1", len(transactions))
+	}
+	if got, want := transactions[0].Memo, "trip settlement "+settled.ChargeID; got != want {
+		t.Errorf("memo = %q, want %q", got, want)
+	}
+}
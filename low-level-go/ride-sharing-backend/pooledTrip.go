@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrDetourTooLong = errors.New("no route keeps every rider's detour under the pool limit")
+	ErrAlreadyInPool = errors.New("rider is already in the pool")
+)
+
+type StopKind string
+
+const (
+	PickupStop  StopKind = "PICKUP"
+	DropoffStop StopKind = "DROPOFF"
+)
+
+type Stop struct {
+	Kind     StopKind
+	Rider    *User
+	Location *Location
+}
+
+type PoolRider struct {
+	Rider   *User
+	Pickup  *Location
+	Dropoff *Location
+}
+
+// RiderFare is one rider's share of a pooled trip.
+type RiderFare struct {
+	Rider       *User
+	RiddenMiles float64 // miles the rider was in the car
+	SharedMiles float64 // ridden miles, each leg divided by riders on board
+	Fare        Money
+}
+
+// DefaultMaxDetourRatio lets each pooled rider's ride be up to 50% longer
+// than going direct.
+const DefaultMaxDetourRatio = 0.5
+
+// PooledTrip carries several riders in one vehicle along an ordered list of
+// pickups and dropoffs. It only routes and prices a pool: there is no pooled
+// trip lifecycle or dispatch, so each rider still books a trip of their own.
+type PooledTrip struct {
+	ID             string
+	Driver         *Driver
+	Riders         []PoolRider
+	Stops          []Stop
+	MaxDetourRatio float64 // 0.5 lets a rider's ride be up to 50% longer than going direct
+	Distance       DistanceProvider
+}
+
+func NewPooledTrip(id string, driver *Driver, maxDetourRatio float64) *PooledTrip {
+	return &PooledTrip{
+		ID:             id,
+		Driver:         driver,
+		MaxDetourRatio: maxDetourRatio,
+		Distance:       HaversineDistance{},
+	}
+}
+
+// AddRider tries every pickup/dropoff insertion point in the current stop
+// list and keeps the shortest route on which no rider, old or new, exceeds
+// the detour limit.
+func (p *PooledTrip) AddRider(rider *User, pickup, dropoff *Location) error {
+	for _, r := range p.Riders {
+		if r.Rider.ID == rider.ID {
+			return ErrAlreadyInPool
+		}
+	}
+	riders := append(append([]PoolRider(nil), p.Riders...), PoolRider{Rider: rider, Pickup: pickup, Dropoff: dropoff})
+
+	var best []Stop
+	bestLength := math.Inf(1)
+	for i := 0; i <= len(p.Stops); i++ {
+		for j := i; j <= len(p.Stops); j++ {
+			stops := make([]Stop, 0, len(p.Stops)+2)
+			stops = append(stops, p.Stops[:i]...)
+			stops = append(stops, Stop{Kind: PickupStop, Rider: rider, Location: pickup})
+			stops = append(stops, p.Stops[i:j]...)
+			stops = append(stops, Stop{Kind: DropoffStop, Rider: rider, Location: dropoff})
+			stops = append(stops, p.Stops[j:]...)
+
+			if !p.withinDetour(riders, stops) {
+				continue
+			}
+			if length := p.routeLength(stops); length < bestLength {
+				best, bestLength = stops, length
+			}
+		}
+	}
+	if best == nil {
+		return ErrDetourTooLong
+	}
+
+	p.Riders = riders
+	p.Stops = best
+	return nil
+}
+
+func (p *PooledTrip) withinDetour(riders []PoolRider, stops []Stop) bool {
+	ridden := p.riddenMiles(stops)
+	for _, r := range riders {
+		direct := p.Distance.DistanceMiles(r.Pickup, r.Dropoff)
+		if ridden[r.Rider.ID] > direct*(1+p.MaxDetourRatio) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PooledTrip) routeLength(stops []Stop) float64 {
+	total := 0.0
+	for i := 1; i < len(stops); i++ {
+		total += p.Distance.DistanceMiles(stops[i-1].Location, stops[i].Location)
+	}
+	return total
+}
+
+func (p *PooledTrip) riddenMiles(stops []Stop) map[string]float64 {
+	ridden, _ := p.walk(stops)
+	return ridden
+}
+
+// walk drives the stop list and returns, per rider ID, the miles spent on
+// board and the miles after splitting every leg among the riders on board.
+func (p *PooledTrip) walk(stops []Stop) (ridden, shared map[string]float64) {
+	ridden = make(map[string]float64)
+	shared = make(map[string]float64)
+	onBoard := make(map[string]bool)
+
+	for i, stop := range stops {
+		if i > 0 && len(onBoard) > 0 {
+			leg := p.Distance.DistanceMiles(stops[i-1].Location, stop.Location)
+			for rider := range onBoard {
+				ridden[rider] += leg
+				shared[rider] += leg / float64(len(onBoard))
+			}
+		}
+		if stop.Kind == PickupStop {
+			onBoard[stop.Rider.ID] = true
+		} else {
+			delete(onBoard, stop.Rider.ID)
+		}
+	}
+	return ridden, shared
+}
+
+// SplitFares prices each rider through the calculator as if they rode
+// alone, requested at at, then scales that fare by the fraction of their ride they didn't
+// share: SharedMiles / RiddenMiles. A rider alone in the car the whole way
+// pays the solo fare; two riders sharing every mile pay half each.
+func (p *PooledTrip) SplitFares(calculator PriceCalculator, at time.Time) ([]RiderFare, error) {
+	ridden, shared := p.walk(p.Stops)
+
+	fares := make([]RiderFare, 0, len(p.Riders))
+	for _, r := range p.Riders {
+		solo := NewTrip(p.ID, r.Rider, r.Pickup, r.Dropoff, at)
+		solo.Driver = p.Driver
+		breakdown := calculator.CalculateFare(solo)
+		if err := breakdown.Err(); err != nil {
+			return nil, err
+		}
+		fare := breakdown.Total()
+		id := r.Rider.ID
+		if ridden[id] > 0 {
+			fare = fare.Mul(shared[id] / ridden[id])
+		}
+		fares = append(fares, RiderFare{
+			Rider:       r.Rider,
+			RiddenMiles: ridden[id],
+			SharedMiles: shared[id],
+			Fare:        fare,
+		})
+	}
+	return fares, nil
+}
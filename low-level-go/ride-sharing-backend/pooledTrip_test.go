@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPooledTripSplitsSharedMiles(t *testing.T) {
+	pool := NewPooledTrip("p1", nil, 0.5)
+	// Riders are matched by ID, not by pointer, as they are when decoded
+	// from separate requests.
+	if err := pool.AddRider(&User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRider(&User{ID: "r2"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRider(&User{ID: "r1"}, testPickup, testDropoff); !errors.Is(err, ErrAlreadyInPool) {
+		t.Errorf("rider added twice: got %v, want ErrAlreadyInPool", err)
+	}
+
+	solo := StandardPricingCalculator{}.CalculateFare(NewTrip("s", &User{ID: "r1"}, testPickup, testDropoff, testNow)).Total()
+	fares, err := pool.SplitFares(StandardPricingCalculator{}, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fare := range fares {
+		if fare.SharedMiles != fare.RiddenMiles/2 {
+			t.Errorf("rider %s shared %.3f of %.3f miles, want half", fare.Rider.ID, fare.SharedMiles, fare.RiddenMiles)
+		}
+		if fare.Fare != solo.Mul(0.5) {
+			t.Errorf("rider %s pays %s, want half the solo fare %s", fare.Rider.ID, fare.Fare, solo)
+		}
+	}
+}
@@ -1,33 +1,213 @@
 package main
 
-import "math"
+import "fmt"
 
+// PriceCalculator itemizes what a trip costs. The fare charged is the
+// breakdown's Total.
 type PriceCalculator interface {
-	CalculateFare(trip *Trip) float64
+	CalculateFare(trip *Trip) FareBreakdown
 }
 
-type StandardPricingCalculator struct{}
+type FareLineKind string
 
 const (
-	baseFare      = 2.50
-	costPerMile   = 1.50
-	costPerMinute = 0.25
+	FareBase      FareLineKind = "BASE"
+	FareDistance  FareLineKind = "DISTANCE"
+	FareTime      FareLineKind = "TIME"
+	FareWait      FareLineKind = "WAIT"
+	FareSurcharge FareLineKind = "SURCHARGE"
+	FareFee       FareLineKind = "FEE"
+	FareDiscount  FareLineKind = "DISCOUNT"
+	FareTax       FareLineKind = "TAX"
 )
 
-func (StandardPricingCalculator) CalculateFare(trip *Trip) float64 {
-	distance := calculateDistance(trip.PickupLocation, trip.DropoffLocation)
-	duration := estimateDuration(distance)
-	return baseFare + (distance * costPerMile) + (duration * costPerMinute)
+// FareLine is one item of the fare the rider is charged.
+type FareLine struct {
+	Kind   FareLineKind
+	Label  string
+	Amount Money
+}
+
+// FareBreakdown is an itemized fare. Lines are in the order they were
+// applied, so multipliers and minimums show up after what they scaled.
+//
+// Every line must be in the fare's currency. A line in another currency,
+// e.g. a zone surcharge priced in USD on a EUR rate card, is left out and
+// recorded in Err, which the caller checks before charging the fare.
+//
+// Miles is the distance billed and DistanceSource where it was measured
+// (GPS, road graph or straight line), so the receipt prints what was charged.
+type FareBreakdown struct {
+	Lines          []FareLine
+	Miles          float64
+	DistanceSource string
+	err            error
+}
+
+// Add appends a line. Zero amounts are kept only for base, distance and
+// time, which every receipt shows.
+func (b *FareBreakdown) Add(kind FareLineKind, label string, amount Money) {
+	if amount.IsZero() && kind != FareBase && kind != FareDistance && kind != FareTime {
+		return
+	}
+	if _, err := b.Total().Add(amount); err != nil {
+		b.fail(fmt.Errorf("fare line %q: %w", label, err))
+		return
+	}
+	b.Lines = append(b.Lines, FareLine{Kind: kind, Label: label, Amount: amount})
+}
+
+// Scale adds a surcharge (or discount, below 1) line that brings the total
+// to multiplier times what it is now.
+func (b *FareBreakdown) Scale(kind FareLineKind, label string, multiplier float64) {
+	total := b.Total()
+	diff, _ := total.Mul(multiplier).Sub(total) // same currency
+	b.Add(kind, label, diff)
+}
+
+// AtLeast adds a line topping the total up to minimum.
+func (b *FareBreakdown) AtLeast(label string, minimum Money) {
+	total := b.Total()
+	c, err := total.Cmp(minimum)
+	if err != nil {
+		b.fail(fmt.Errorf("fare line %q: %w", label, err))
+		return
+	}
+	if c < 0 {
+		topUp, _ := minimum.Sub(total)
+		b.Add(FareSurcharge, label, topUp)
+	}
+}
+
+// Err returns the first line that could not be added because of its
+// currency, or nil.
+func (b FareBreakdown) Err() error {
+	return b.err
+}
+
+func (b *FareBreakdown) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Total sums the lines. Add keeps them in one currency, so it can't fail.
+func (b FareBreakdown) Total() Money {
+	return sumLines(b.Lines, func(FareLine) bool { return true })
+}
+
+// Sum adds up the lines of one kind.
+func (b FareBreakdown) Sum(kind FareLineKind) Money {
+	return sumLines(b.Lines, func(line FareLine) bool { return line.Kind == kind })
+}
+
+func sumLines(lines []FareLine, include func(FareLine) bool) Money {
+	var sum Money
+	for _, line := range lines {
+		if include(line) {
+			sum.Amount += line.Amount.Amount
+			if line.Amount.Currency != "" {
+				sum.Currency = line.Amount.Currency
+			}
+		}
+	}
+	return sum
+}
+
+// StandardPricingCalculator charges a base fare plus distance and time
+// along the trip's waypoints, and the wait at stops. The zero value uses
+// Haversine distance and a flat 30 mph.
+type StandardPricingCalculator struct {
+	Distance DistanceProvider
+	ETA      ETAProvider
+}
+
+var (
+	baseFare      = NewMoney(250, USD)
+	costPerMile   = NewMoney(150, USD)
+	costPerMinute = NewMoney(25, USD)
+	costPerWait   = NewMoney(35, USD)
+)
+
+const defaultSpeedMPH = 30.0
+
+func NewStandardPricingCalculator(distance DistanceProvider, eta ETAProvider) StandardPricingCalculator {
+	return StandardPricingCalculator{Distance: distance, ETA: eta}
+}
+
+func (c StandardPricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	rates := standardRates()
+	distance, duration, source := legs(trip, c.distanceProvider(), c.etaProvider())
+	fare := meterFare(rates, distance, source, duration)
+	addStopWait(&fare, rates, trip)
+	return fare
+}
+
+func standardRates() ClassRates {
+	return ClassRates{BaseFare: baseFare, PerMile: costPerMile, PerMinute: costPerMinute, WaitPerMinute: costPerWait}
+}
+
+// meterFare itemizes the base, distance and time charges of rates, and
+// records the miles billed and where they were measured.
+func meterFare(rates ClassRates, miles float64, source string, minutes float64) FareBreakdown {
+	b := FareBreakdown{Miles: miles, DistanceSource: source}
+	b.Add(FareBase, "Base fare", rates.BaseFare)
+	b.Add(FareDistance, fmt.Sprintf("Distance (%.1f mi)", miles), rates.PerMile.Mul(miles))
+	b.Add(FareTime, fmt.Sprintf("Time (%.0f min)", minutes), rates.PerMinute.Mul(minutes))
+	return b
+}
+
+func (c StandardPricingCalculator) distanceProvider() DistanceProvider {
+	if c.Distance == nil {
+		return HaversineDistance{}
+	}
+	return c.Distance
+}
+
+func (c StandardPricingCalculator) etaProvider() ETAProvider {
+	if c.ETA == nil {
+		return ConstantSpeedETA{SpeedMPH: defaultSpeedMPH, Distance: c.distanceProvider()}
+	}
+	return c.ETA
 }
 
 func calculateDistance(from, to *Location) float64 {
-	latDiff := to.Latitude - from.Latitude
-	lonDiff := to.Longitude - from.Longitude
+	return HaversineDistance{}.DistanceMiles(from, to)
+}
+
+func estimateDuration(distanceMiles, speedMPH float64) float64 {
+	if speedMPH <= 0 {
+		speedMPH = defaultSpeedMPH
+	}
+	return (distanceMiles / speedMPH) * 60
+}
+
+// TaxPricingCalculator adds tax at Rate on everything the Base fare
+// charges, discounts included. Settlement books the tax lines as payable.
+type TaxPricingCalculator struct {
+	Base  PriceCalculator
+	Label string
+	Rate  float64
+}
+
+func (c TaxPricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	return c.taxed(c.Base.CalculateFare(trip))
+}
 
-	// it uses the Haversine formula (simplified)
-	return math.Sqrt(math.Pow(latDiff, 2)+math.Pow(lonDiff, 2)) * 69
+// Quote taxes the base calculator's quote, so the tax is on the locked-in
+// price.
+func (c TaxPricingCalculator) Quote(trip *Trip) FareBreakdown {
+	if quoter, ok := c.Base.(Quoter); ok {
+		return c.taxed(quoter.Quote(trip))
+	}
+	return c.CalculateFare(trip)
 }
 
-func estimateDuration(distanceMiles float64) float64 {
-	return (distanceMiles / 30.0) * 60
+func (c TaxPricingCalculator) taxed(fare FareBreakdown) FareBreakdown {
+	label := c.Label
+	if label == "" {
+		label = "Tax"
+	}
+	fare.Add(FareTax, fmt.Sprintf("%s (%g%%)", label, c.Rate*100), fare.Total().Mul(c.Rate))
+	return fare
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPromoNotFound     = errors.New("promo code not found")
+	ErrPromoExpired      = errors.New("promo code has expired")
+	ErrPromoUsageLimit   = errors.New("promo code usage limit reached")
+	ErrPromoOutsideArea  = errors.New("promo code is not valid in this area")
+	ErrPromoNotFirstRide = errors.New("promo code is only valid on a first ride")
+)
+
+type PromoKind string
+
+const (
+	PromoPercent       PromoKind = "PERCENT"
+	PromoFlat          PromoKind = "FLAT"
+	PromoFirstRideFree PromoKind = "FIRST_RIDE_FREE"
+)
+
+// PromoArea restricts a code to pickups within RadiusMiles of Center.
+type PromoArea struct {
+	Center      *Location
+	RadiusMiles float64
+}
+
+type PromoCode struct {
+	Code string
+	Kind PromoKind
+	// Percent is the percentage off for PERCENT codes.
+	Percent float64
+	// Amount is the amount off for FLAT codes.
+	Amount Money
+	// MaxDiscount caps the discount; zero means no cap.
+	MaxDiscount    Money
+	MaxUsesPerUser int // 0 means unlimited
+	ExpiresAt      time.Time
+	Area           *PromoArea
+}
+
+type promoRedemption struct {
+	code     string
+	riderID  string
+	discount Money
+}
+
+// promoHold reserves a redemption for the completion attempts of one trip
+// that are being saved, keyed by the attempt's *Trip.
+type promoHold struct {
+	promoRedemption
+	attempts map[*Trip]bool
+}
+
+// PromoEngine validates promo codes when a trip is requested and redeems
+// them when its completion is saved. Pricing only previews a code. Before
+// the completion is saved, ReserveRedemption checks the code again and holds
+// the use under the engine's lock, so concurrent completions can't both
+// take the last one; TripCompleted makes the hold a redemption, and
+// ReleaseRedemption drops it if the save is thrown away.
+type PromoEngine struct {
+	clock Clock
+
+	mu        sync.Mutex
+	codes     map[string]PromoCode
+	uses      map[string]map[string]int // code -> rider ID -> redemptions
+	redeemed  map[string]promoRedemption
+	holds     map[string]*promoHold // trip ID -> redemption being saved
+	rides     map[string]int        // rider ID -> completed rides
+	rideTrips map[string]bool       // trip IDs already counted in rides
+}
+
+func NewPromoEngine(clock Clock) *PromoEngine {
+	return &PromoEngine{
+		clock:     clock,
+		codes:     make(map[string]PromoCode),
+		uses:      make(map[string]map[string]int),
+		redeemed:  make(map[string]promoRedemption),
+		holds:     make(map[string]*promoHold),
+		rides:     make(map[string]int),
+		rideTrips: make(map[string]bool),
+	}
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (e *PromoEngine) AddCode(code PromoCode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	code.Code = normalizePromoCode(code.Code)
+	e.codes[code.Code] = code
+}
+
+// Validate checks that the code could be applied to the trip right now.
+func (e *PromoEngine) Validate(code string, trip *Trip) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.check(normalizePromoCode(code), trip)
+	return err
+}
+
+// Preview returns the discount the trip's code would give on fare, without
+// redeeming it.
+func (e *PromoEngine) Preview(trip *Trip, fare Money) (Money, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	promo, err := e.check(normalizePromoCode(trip.PromoCode), trip)
+	if err != nil {
+		return Money{}, err
+	}
+	return promo.discount(fare)
+}
+
+// ReserveRedemption is a BeforeTransitionHook. When a completion was priced
+// with a promo discount, it checks the code again, counting uses other trips
+// hold, and holds this one. If the code was used up meanwhile it returns
+// ErrStaleTransition, and the completion is priced again without it.
+func (e *PromoEngine) ReserveRedemption(trip *Trip, transition StateTransition) error {
+	if transition.To != Completed {
+		return nil
+	}
+	redemption, ok := promoRedemptionOf(trip)
+	if !ok {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if hold, ok := e.holds[trip.ID]; ok {
+		hold.attempts[trip] = true
+		return nil
+	}
+	if _, err := e.check(redemption.code, trip); err != nil {
+		return fmt.Errorf("%w: %v", ErrStaleTransition, err)
+	}
+	e.holds[trip.ID] = &promoHold{promoRedemption: redemption, attempts: map[*Trip]bool{trip: true}}
+	return nil
+}
+
+// ReleaseRedemption is the abort hook for ReserveRedemption: it drops the
+// hold of a completion attempt that was not saved.
+func (e *PromoEngine) ReleaseRedemption(trip *Trip, transition StateTransition) {
+	if transition.To != Completed {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.release(trip)
+}
+
+func (e *PromoEngine) release(trip *Trip) {
+	hold, ok := e.holds[trip.ID]
+	if !ok {
+		return
+	}
+	delete(hold.attempts, trip)
+	if len(hold.attempts) == 0 {
+		delete(e.holds, trip.ID)
+	}
+}
+
+// TripCompleted is an AfterTransitionHook. Once a completion is saved it
+// counts the ride toward the rider's history and redeems the promo discount
+// the fare was priced with. Recording is keyed by trip ID, so a trip is
+// never counted twice.
+func (e *PromoEngine) TripCompleted(trip *Trip, transition StateTransition) {
+	if transition.To != Completed {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	defer e.release(trip)
+	riderID := riderIDOf(trip)
+	if !e.rideTrips[trip.ID] {
+		e.rideTrips[trip.ID] = true
+		e.rides[riderID]++
+	}
+
+	redemption, ok := promoRedemptionOf(trip)
+	if !ok {
+		return
+	}
+	if _, ok := e.redeemed[trip.ID]; ok {
+		return
+	}
+	if e.uses[redemption.code] == nil {
+		e.uses[redemption.code] = make(map[string]int)
+	}
+	e.uses[redemption.code][riderID]++
+	e.redeemed[trip.ID] = redemption
+}
+
+// promoRedemptionOf finds the discount line the trip's code put on its fare.
+func promoRedemptionOf(trip *Trip) (promoRedemption, bool) {
+	code := normalizePromoCode(trip.PromoCode)
+	if code == "" {
+		return promoRedemption{}, false
+	}
+	for _, line := range trip.FareBreakdown {
+		if line.Kind == FareDiscount && line.Label == promoLabel(code) {
+			return promoRedemption{code: code, riderID: riderIDOf(trip), discount: line.Amount.Neg()}, true
+		}
+	}
+	return promoRedemption{}, false
+}
+
+// held counts the redemptions other trips of the rider are saving: all of
+// them, or only those of code when it is not empty.
+func (e *PromoEngine) held(code, riderID, tripID string) int {
+	n := 0
+	for id, hold := range e.holds {
+		if id == tripID || hold.riderID != riderID || (code != "" && hold.code != code) {
+			continue
+		}
+		if _, done := e.redeemed[id]; !done {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *PromoEngine) check(code string, trip *Trip) (PromoCode, error) {
+	promo, ok := e.codes[code]
+	if !ok {
+		return PromoCode{}, ErrPromoNotFound
+	}
+	if !promo.ExpiresAt.IsZero() && !e.clock.Now().Before(promo.ExpiresAt) {
+		return PromoCode{}, ErrPromoExpired
+	}
+	riderID := riderIDOf(trip)
+	if promo.MaxUsesPerUser > 0 && e.uses[code][riderID]+e.held(code, riderID, trip.ID) >= promo.MaxUsesPerUser {
+		return PromoCode{}, ErrPromoUsageLimit
+	}
+	if promo.Area != nil && calculateDistance(promo.Area.Center, trip.PickupLocation) > promo.Area.RadiusMiles {
+		return PromoCode{}, ErrPromoOutsideArea
+	}
+	if promo.Kind == PromoFirstRideFree && e.rides[riderID]+e.held("", riderID, trip.ID) > 0 && !e.rideTrips[trip.ID] {
+		return PromoCode{}, ErrPromoNotFirstRide
+	}
+	return promo, nil
+}
+
+// discount never exceeds the fare, so a trip can't go below zero. A FLAT
+// amount or cap in another currency than the fare is an error.
+func (p PromoCode) discount(fare Money) (Money, error) {
+	var discount Money
+	switch p.Kind {
+	case PromoPercent:
+		discount = fare.Mul(p.Percent / 100)
+	case PromoFlat:
+		discount = p.Amount
+	case PromoFirstRideFree:
+		discount = fare
+	}
+
+	if p.MaxDiscount.IsPositive() {
+		c, err := discount.Cmp(p.MaxDiscount)
+		if err != nil {
+			return Money{}, err
+		}
+		if c > 0 {
+			discount = p.MaxDiscount
+		}
+	}
+	c, err := discount.Cmp(fare)
+	if err != nil {
+		return Money{}, err
+	}
+	if c > 0 {
+		discount = fare
+	}
+	if discount.IsNegative() {
+		discount = NewMoney(0, fare.Currency)
+	}
+	return discount, nil
+}
+
+func promoLabel(code string) string {
+	return "Promo " + code
+}
+
+func riderIDOf(trip *Trip) string {
+	if trip.Rider == nil {
+		return ""
+	}
+	return trip.Rider.ID
+}
+
+// PromoPricingCalculator takes the Base fare and subtracts the trip's promo
+// discount. It only previews the code; register the PromoEngine's
+// ReserveRedemption, ReleaseRedemption and TripCompleted hooks on the
+// TripService to redeem it once the completion is saved. A code that is no
+// longer valid at completion is dropped and the full fare charged.
+type PromoPricingCalculator struct {
+	Base   PriceCalculator
+	Promos *PromoEngine
+}
+
+func (c PromoPricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	fare := c.Base.CalculateFare(trip)
+	if trip.PromoCode == "" {
+		return fare
+	}
+	if discount, err := c.Promos.Preview(trip, fare.Total()); err == nil {
+		fare.Add(FareDiscount, promoLabel(normalizePromoCode(trip.PromoCode)), discount.Neg())
+	}
+	return fare
+}
+
+// Quote lets the base lock its surge into the trip, then prices the trip
+// again with the discount previewed, as CalculateFare does.
+func (c PromoPricingCalculator) Quote(trip *Trip) FareBreakdown {
+	if quoter, ok := c.Base.(Quoter); ok {
+		quoter.Quote(trip)
+	}
+	return c.CalculateFare(trip)
+}
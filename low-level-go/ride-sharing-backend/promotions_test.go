@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newPromoService(t *testing.T) (*TripService, *PromoEngine, PriceCalculator) {
+	t.Helper()
+	trips, clock := newTestService(t)
+	promos := NewPromoEngine(clock)
+	promos.AddCode(PromoCode{Code: "ONCE", Kind: PromoPercent, Percent: 50, MaxUsesPerUser: 1})
+	trips.OnBeforeTransition(promos.ReserveRedemption)
+	trips.OnAbortedTransition(promos.ReleaseRedemption)
+	trips.OnAfterTransition(promos.TripCompleted)
+	return trips, promos, PromoPricingCalculator{Base: StandardPricingCalculator{}, Promos: promos}
+}
+
+func promoTrip(id string) *Trip {
+	trip := inProgressTrip(id)
+	trip.PromoCode = "once"
+	return trip
+}
+
+func TestPromoUsedOnlyWhenCompletionIsSaved(t *testing.T) {
+	trips, promos, pricing := newPromoService(t)
+
+	// A completion that prices the trip and then loses to a cancel.
+	lost := promoTrip("t1")
+	if _, err := trips.SubmitTrip(lost); err != nil {
+		t.Fatal(err)
+	}
+	trips.OnBeforeTransition(func(trip *Trip, transition StateTransition) error {
+		if trip.ID == "t1" && transition.To == Completed {
+			if _, err := trips.CancelTrip("t1", CancelledByDriver, "", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	})
+	if _, err := trips.CompleteTrip("t1", pricing); err == nil {
+		t.Fatal("completion of a cancelled trip succeeded")
+	}
+	if err := promos.Validate("ONCE", lost); err != nil {
+		t.Fatalf("code used up by a lost completion: %v", err)
+	}
+
+	if _, err := trips.SubmitTrip(promoTrip("t2")); err != nil {
+		t.Fatal(err)
+	}
+	trip, err := trips.CompleteTrip("t2", pricing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := StandardPricingCalculator{}.CalculateFare(trip).Total()
+	want := FareLine{Kind: FareDiscount, Label: "Promo ONCE", Amount: full.Mul(0.5).Neg()}
+	if last := trip.FareBreakdown[len(trip.FareBreakdown)-1]; last != want {
+		t.Errorf("last fare line %+v, want %+v", last, want)
+	}
+	if err := promos.Validate("ONCE", promoTrip("t3")); !errors.Is(err, ErrPromoUsageLimit) {
+		t.Errorf("after a saved completion: got %v, want ErrPromoUsageLimit", err)
+	}
+}
+
+func TestConcurrentCompletionsRedeemOnce(t *testing.T) {
+	for _, code := range []PromoCode{
+		{Code: "ONCE", Kind: PromoPercent, Percent: 50, MaxUsesPerUser: 1},
+		{Code: "FIRSTRIDE", Kind: PromoFirstRideFree},
+	} {
+		t.Run(code.Code, func(t *testing.T) {
+			trips, clock := newTestService(t)
+			promos := NewPromoEngine(clock)
+			promos.AddCode(code)
+			pricing := PromoPricingCalculator{Base: StandardPricingCalculator{}, Promos: promos}
+
+			// Hold both first attempts until each has been priced with the
+			// discount, so they race for the one redemption.
+			var mu sync.Mutex
+			arrived := 0
+			bothPriced := make(chan struct{})
+			trips.OnBeforeTransition(func(trip *Trip, transition StateTransition) error {
+				mu.Lock()
+				arrived++
+				if arrived == 2 {
+					close(bothPriced)
+				}
+				first := arrived <= 2
+				mu.Unlock()
+				if first {
+					<-bothPriced
+				}
+				return nil
+			})
+			trips.OnBeforeTransition(promos.ReserveRedemption)
+			trips.OnAbortedTransition(promos.ReleaseRedemption)
+			trips.OnAfterTransition(promos.TripCompleted)
+
+			var wg sync.WaitGroup
+			completed := make([]*Trip, 2)
+			for i, id := range []string{"t1", "t2"} {
+				trip := inProgressTrip(id)
+				trip.PromoCode = code.Code
+				if _, err := trips.SubmitTrip(trip); err != nil {
+					t.Fatal(err)
+				}
+				wg.Add(1)
+				go func(i int, id string) {
+					defer wg.Done()
+					trip, err := trips.CompleteTrip(id, pricing)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					completed[i] = trip
+				}(i, id)
+			}
+			wg.Wait()
+
+			discounted := 0
+			for _, trip := range completed {
+				if _, ok := promoRedemptionOf(trip); ok {
+					discounted++
+				}
+			}
+			if discounted != 1 {
+				t.Errorf("%d of 2 concurrent completions got the discount, want 1", discounted)
+			}
+		})
+	}
+}
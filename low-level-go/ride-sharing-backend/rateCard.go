@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ClassRates struct {
+	BaseFare    Money
+	PerMile     Money
+	PerMinute   Money
+	MinimumFare Money
+	// WaitPerMinute bills waiting at stops; PerMinute when unset.
+	WaitPerMinute Money
+}
+
+func (r ClassRates) waitPerMinute() Money {
+	if r.WaitPerMinute.IsZero() {
+		return r.PerMinute
+	}
+	return r.WaitPerMinute
+}
+
+// TimeWindow applies Multiplier to trips picked up between Start and End
+// ("HH:MM", local to the rate card timezone). A window may wrap midnight.
+// An empty Days list means every day; a Multiplier below 1 is a discount.
+type TimeWindow struct {
+	Name       string   `json:"name"`
+	Start      string   `json:"start"`
+	End        string   `json:"end"`
+	Days       []string `json:"days"`
+	Multiplier float64  `json:"multiplier"`
+}
+
+type AirportSurcharge struct {
+	Name        string
+	Latitude    float64
+	Longitude   float64
+	RadiusMiles float64
+	Surcharge   Money
+}
+
+type RateCard struct {
+	Currency    Currency
+	Timezone    string
+	BookingFee  Money
+	Classes     map[VehicleClass]ClassRates
+	TimeWindows []TimeWindow
+	Airports    []AirportSurcharge
+
+	location *time.Location
+}
+
+// rateCardFile is the JSON layout of a rate card. Amounts are decimal
+// numbers in the card's currency and are parsed exactly into Money.
+type rateCardFile struct {
+	Currency    Currency                        `json:"currency"`
+	Timezone    string                          `json:"timezone"`
+	BookingFee  json.Number                     `json:"booking_fee"`
+	Classes     map[VehicleClass]classRatesFile `json:"classes"`
+	TimeWindows []TimeWindow                    `json:"time_windows"`
+	Airports    []airportSurchargeFile          `json:"airports"`
+}
+
+type classRatesFile struct {
+	BaseFare    json.Number `json:"base_fare"`
+	PerMile     json.Number `json:"per_mile"`
+	PerMinute   json.Number `json:"per_minute"`
+	MinimumFare json.Number `json:"minimum_fare"`
+	// WaitPerMinute is optional.
+	WaitPerMinute json.Number `json:"wait_per_minute"`
+}
+
+type airportSurchargeFile struct {
+	Name        string      `json:"name"`
+	Latitude    float64     `json:"latitude"`
+	Longitude   float64     `json:"longitude"`
+	RadiusMiles float64     `json:"radius_miles"`
+	Surcharge   json.Number `json:"surcharge"`
+}
+
+func ParseRateCard(data []byte) (*RateCard, error) {
+	var file rateCardFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse rate card: %w", err)
+	}
+	if file.Currency == "" {
+		file.Currency = USD
+	}
+
+	var parseErr error
+	money := func(field string, n json.Number) Money {
+		if n == "" {
+			return NewMoney(0, file.Currency)
+		}
+		m, err := ParseMoney(n.String(), file.Currency)
+		if err == nil && m.IsNegative() {
+			err = fmt.Errorf("%s must not be negative, got %s", field, n)
+		}
+		if err != nil && parseErr == nil {
+			parseErr = err
+		}
+		return m
+	}
+
+	card := &RateCard{
+		Currency:    file.Currency,
+		Timezone:    file.Timezone,
+		BookingFee:  money("booking_fee", file.BookingFee),
+		Classes:     make(map[VehicleClass]ClassRates, len(file.Classes)),
+		TimeWindows: file.TimeWindows,
+	}
+	for class, rates := range file.Classes {
+		if !class.Valid() && parseErr == nil {
+			parseErr = fmt.Errorf("%w %q", ErrUnknownVehicleClass, class)
+		}
+		card.Classes[class] = ClassRates{
+			BaseFare:      money(string(class)+" base_fare", rates.BaseFare),
+			PerMile:       money(string(class)+" per_mile", rates.PerMile),
+			PerMinute:     money(string(class)+" per_minute", rates.PerMinute),
+			MinimumFare:   money(string(class)+" minimum_fare", rates.MinimumFare),
+			WaitPerMinute: money(string(class)+" wait_per_minute", rates.WaitPerMinute),
+		}
+	}
+	for _, airport := range file.Airports {
+		card.Airports = append(card.Airports, AirportSurcharge{
+			Name:        airport.Name,
+			Latitude:    airport.Latitude,
+			Longitude:   airport.Longitude,
+			RadiusMiles: airport.RadiusMiles,
+			Surcharge:   money(airport.Name+" surcharge", airport.Surcharge),
+		})
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("parse rate card: %w", parseErr)
+	}
+	if _, ok := card.Classes[Economy]; !ok {
+		return nil, fmt.Errorf("rate card must define %s rates", Economy)
+	}
+
+	card.location = time.UTC
+	if card.Timezone != "" {
+		loc, err := time.LoadLocation(card.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("rate card timezone: %w", err)
+		}
+		card.location = loc
+	}
+
+	for i, window := range card.TimeWindows {
+		if _, err := minuteOfDay(window.Start); err != nil {
+			return nil, fmt.Errorf("time window %q: %w", window.Name, err)
+		}
+		if _, err := minuteOfDay(window.End); err != nil {
+			return nil, fmt.Errorf("time window %q: %w", window.Name, err)
+		}
+		if window.Multiplier <= 0 {
+			return nil, fmt.Errorf("time window %q: multiplier must be positive, got %v", window.Name, window.Multiplier)
+		}
+		days := make([]string, len(window.Days))
+		for j, d := range window.Days {
+			day, ok := weekdayAbbrev(d)
+			if !ok {
+				return nil, fmt.Errorf("time window %q: unknown weekday %q", window.Name, d)
+			}
+			days[j] = day
+		}
+		card.TimeWindows[i].Days = days
+	}
+	return card, nil
+}
+
+// weekdayAbbrev accepts a weekday's full or three-letter name in any case
+// and returns the three-letter form timeMultiplier matches on.
+func weekdayAbbrev(name string) (string, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := d.String()
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return full[:3], true
+		}
+	}
+	return "", false
+}
+
+func LoadRateCard(path string) (*RateCard, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRateCard(data)
+}
+
+// ratesFor returns the class's rates. A card must price ECONOMY but may
+// leave out the other classes, which are then charged ECONOMY rates.
+func (r *RateCard) ratesFor(class VehicleClass) ClassRates {
+	if rates, ok := r.Classes[class]; ok {
+		return rates
+	}
+	return r.Classes[Economy]
+}
+
+// timeMultiplier returns the highest multiplier of all windows covering t
+// and that window's name, or 1 when none does. A multiplier below 1 is a
+// discount window. Windows don't stack, so overlapping night and peak rules
+// can't compound.
+func (r *RateCard) timeMultiplier(t time.Time) (float64, string) {
+	t = t.In(r.location)
+	now := t.Hour()*60 + t.Minute()
+	day := t.Weekday().String()[:3]
+
+	multiplier, name, matched := 1.0, "", false
+	for _, window := range r.TimeWindows {
+		if !window.appliesOn(day) {
+			continue
+		}
+		start, _ := minuteOfDay(window.Start)
+		end, _ := minuteOfDay(window.End)
+
+		inside := now >= start && now < end
+		if start > end {
+			inside = now >= start || now < end
+		}
+		if inside && (!matched || window.Multiplier > multiplier) {
+			multiplier, name, matched = window.Multiplier, window.Name, true
+		}
+	}
+	return multiplier, name
+}
+
+func (w TimeWindow) appliesOn(day string) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// airportSurcharge returns the largest surcharge of the airports around the
+// pickup. ParseRateCard reads every surcharge in the card's currency, so the
+// amounts compare directly.
+func (r *RateCard) airportSurcharge(pickup *Location) (Money, string) {
+	surcharge, name := NewMoney(0, r.Currency), ""
+	for _, airport := range r.Airports {
+		center := &Location{Latitude: airport.Latitude, Longitude: airport.Longitude}
+		if calculateDistance(center, pickup) <= airport.RadiusMiles && airport.Surcharge.Amount > surcharge.Amount {
+			surcharge, name = airport.Surcharge, airport.Name
+		}
+	}
+	return surcharge, name
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// RateCardStore holds the current rate card and swaps it when the file on
+// disk changes, so ops can edit prices without a redeploy.
+type RateCardStore struct {
+	path string
+
+	mu      sync.RWMutex
+	card    *RateCard
+	modTime time.Time
+}
+
+func NewRateCardStore(path string) (*RateCardStore, error) {
+	store := &RateCardStore{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *RateCardStore) Current() *RateCard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.card
+}
+
+// Reload reads the file again. A card that fails to parse, or that switches
+// to another currency, is rejected and the previous one stays in effect;
+// zone surcharges were checked against the first card's currency.
+func (s *RateCardStore) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	card, err := LoadRateCard(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.card != nil && card.Currency != s.card.Currency {
+		return fmt.Errorf("rate card: currency changed from %s to %s; restart to switch", s.card.Currency, card.Currency)
+	}
+	s.card = card
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes.
+func (s *RateCardStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				log.Printf("rate card: %v", err)
+				continue
+			}
+			s.mu.RLock()
+			changed := info.ModTime().After(s.modTime)
+			s.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				log.Printf("rate card: keeping previous rates: %v", err)
+			}
+		}
+	}
+}
+
+// RuleBasedPricingCalculator prices a trip from the current rate card:
+// class rates scaled by the time-of-day multiplier, raised to the minimum
+// fare, plus the wait at stops, airport surcharge and booking fee.
+type RuleBasedPricingCalculator struct {
+	Rates    *RateCardStore
+	Distance DistanceProvider
+	ETA      ETAProvider
+}
+
+func (c RuleBasedPricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	card := c.Rates.Current()
+	rates := card.ratesFor(trip.VehicleClass)
+
+	standard := StandardPricingCalculator{Distance: c.Distance, ETA: c.ETA}
+	distance, duration, source := legs(trip, standard.distanceProvider(), standard.etaProvider())
+
+	fare := meterFare(rates, distance, source, duration)
+	card.adjust(&fare, rates, trip)
+	return fare
+}
+
+// adjust applies everything the card adds on top of the metered base,
+// distance and time lines.
+func (r *RateCard) adjust(fare *FareBreakdown, rates ClassRates, trip *Trip) {
+	if multiplier, window := r.timeMultiplier(trip.pickupTime()); multiplier != 1 {
+		kind := FareSurcharge
+		if multiplier < 1 {
+			kind = FareDiscount
+		}
+		fare.Scale(kind, fmt.Sprintf("%s ×%.2g", titleCase(window), multiplier), multiplier)
+	}
+	fare.AtLeast("Minimum fare", rates.MinimumFare)
+	addStopWait(fare, rates, trip)
+	if surcharge, airport := r.airportSurcharge(trip.PickupLocation); surcharge.IsPositive() {
+		fare.Add(FareSurcharge, airport+" airport pickup", surcharge)
+	}
+	fare.Add(FareFee, "Booking fee", r.BookingFee)
+}
+
+func titleCase(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validRateCard = `{
+  "currency": "USD",
+  "classes": {"ECONOMY": {"base_fare": 2.50, "per_mile": 1.50, "per_minute": 0.25, "minimum_fare": 7.00}},
+  "time_windows": [{"name": "peak", "start": "07:00", "end": "09:00", "days": ["mon", "Tuesday"], "multiplier": 1.3}]
+}`
+
+func TestParseRateCardNormalizesDays(t *testing.T) {
+	card, err := ParseRateCard([]byte(validRateCard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(card.TimeWindows[0].Days, ","); got != "Mon,Tue" {
+		t.Errorf("days = %s, want Mon,Tue", got)
+	}
+	tuesday := time.Date(2026, 10, 13, 8, 0, 0, 0, time.UTC)
+	if multiplier, _ := card.timeMultiplier(tuesday); multiplier != 1.3 {
+		t.Errorf("Tuesday 08:00 multiplier = %v, want 1.3", multiplier)
+	}
+}
+
+func TestParseRateCardRejectsInvalidWindows(t *testing.T) {
+	tests := map[string]string{
+		"unknown weekday":     `"days": ["Mon", "Funday"], "multiplier": 1.3`,
+		"zero multiplier":     `"multiplier": 0`,
+		"negative multiplier": `"multiplier": -1.2`,
+		"missing multiplier":  `"days": ["Mon"]`,
+	}
+	for name, window := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := `{"classes": {"ECONOMY": {"base_fare": 2.50}},
+			  "time_windows": [{"name": "peak", "start": "07:00", "end": "09:00", ` + window + `}]}`
+			if _, err := ParseRateCard([]byte(data)); err == nil {
+				t.Error("ParseRateCard accepted the card")
+			}
+		})
+	}
+}
+
+func TestRateCardStoreKeepsPreviousCardOnInvalidReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rate-card.json")
+	if err := os.WriteFile(path, []byte(validRateCard), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewRateCardStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := store.Current()
+
+	invalid := strings.Replace(validRateCard, `"multiplier": 1.3`, `"multiplier": -1`, 1)
+	if err := os.WriteFile(path, []byte(invalid), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid card")
+	}
+	if store.Current() != previous {
+		t.Error("invalid card replaced the previous one")
+	}
+}
+
+func TestScheduledTripPricedAtPickupTime(t *testing.T) {
+	card, err := ParseRateCard([]byte(validRateCard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2026, 10, 12, 20, 0, 0, 0, time.UTC)
+	tuesdayPeak := time.Date(2026, 10, 13, 8, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		trip *Trip
+		want bool
+	}{
+		"asap outside peak":     {NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, monday), false},
+		"booked into peak":      {NewScheduledTrip("t2", &User{ID: "r1"}, testPickup, testDropoff, monday, tuesdayPeak), true},
+		"booked out of peak":    {NewScheduledTrip("t3", &User{ID: "r1"}, testPickup, testDropoff, tuesdayPeak, monday.AddDate(0, 0, 7)), false},
+		"asap during peak hour": {NewTrip("t4", &User{ID: "r1"}, testPickup, testDropoff, tuesdayPeak), true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rates := card.ratesFor(Economy)
+			fare := meterFare(rates, 5, "estimated", 15)
+			card.adjust(&fare, rates, tc.trip)
+			peak := false
+			for _, line := range fare.Lines {
+				peak = peak || strings.HasPrefix(line.Label, "Peak")
+			}
+			if peak != tc.want {
+				t.Errorf("peak surcharge = %v, want %v: %+v", peak, tc.want, fare.Lines)
+			}
+		})
+	}
+}
+
+func TestParseRateCardRejectsNegativeAmounts(t *testing.T) {
+	tests := map[string]string{
+		"booking fee":       `"booking_fee": -1.00, "classes": {"ECONOMY": {"base_fare": 2.50}}`,
+		"base fare":         `"classes": {"ECONOMY": {"base_fare": -2.50}}`,
+		"per mile":          `"classes": {"ECONOMY": {"base_fare": 2.50, "per_mile": -1.50}}`,
+		"other class rate":  `"classes": {"ECONOMY": {"base_fare": 2.50}, "PREMIUM": {"per_minute": -0.10}}`,
+		"airport surcharge": `"classes": {"ECONOMY": {"base_fare": 2.50}}, "airports": [{"name": "SFO", "surcharge": -5}]`,
+	}
+	for name, card := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseRateCard([]byte("{" + card + "}")); err == nil {
+				t.Error("ParseRateCard accepted the card")
+			}
+		})
+	}
+}
+
+func TestTimeWindowDiscount(t *testing.T) {
+	card, err := ParseRateCard([]byte(`{
+	  "classes": {"ECONOMY": {"base_fare": 10.00}},
+	  "time_windows": [
+	    {"name": "off-peak", "start": "10:00", "end": "16:00", "multiplier": 0.8},
+	    {"name": "lunch", "start": "12:00", "end": "13:00", "multiplier": 1.2}
+	  ]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at       time.Time
+		want     float64
+		wantName string
+	}{
+		{time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC), 1, ""},
+		{time.Date(2026, 10, 13, 11, 0, 0, 0, time.UTC), 0.8, "off-peak"},
+		{time.Date(2026, 10, 13, 12, 30, 0, 0, time.UTC), 1.2, "lunch"},
+	}
+	for _, tc := range tests {
+		if multiplier, name := card.timeMultiplier(tc.at); multiplier != tc.want || name != tc.wantName {
+			t.Errorf("%s: multiplier %v (%q), want %v (%q)", tc.at.Format("15:04"), multiplier, name, tc.want, tc.wantName)
+		}
+	}
+
+	rates := card.ratesFor(Economy)
+	fare := meterFare(rates, 0, "estimated", 0)
+	card.adjust(&fare, rates, NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, tests[1].at))
+	if got := fare.Total(); got != MustParseMoney("8.00", USD) {
+		t.Errorf("off-peak fare = %s, want 8.00", got)
+	}
+	if got := fare.Sum(FareDiscount); got != MustParseMoney("-2.00", USD) {
+		t.Errorf("off-peak discount line = %s, want -2.00", got)
+	}
+}
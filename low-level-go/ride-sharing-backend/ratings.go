@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTripNotCompleted = errors.New("only completed trips can be rated")
+	ErrAlreadyRated     = errors.New("trip already rated by this party")
+	ErrInvalidStars     = errors.New("stars must be between 1 and 5")
+	ErrRatingClosed     = errors.New("the trip can no longer be rated")
+)
+
+type RatingParty string
+
+const (
+	RatedByRider  RatingParty = "RIDER"
+	RatedByDriver RatingParty = "DRIVER"
+)
+
+type Rating struct {
+	TripID  string
+	By      RatingParty
+	RaterID string
+	RateeID string
+	Stars   int
+	Comment string
+	Tags    []string
+	At      time.Time
+}
+
+// reputation is an exponentially decayed average: every rating counts with
+// weight 0.5^(age / half-life), so recent trips dominate the score.
+type reputation struct {
+	weightedStars float64
+	weight        float64
+	count         int
+	updatedAt     time.Time
+}
+
+func (r *reputation) add(stars int, at time.Time, halfLife time.Duration) {
+	if r.count > 0 && at.After(r.updatedAt) {
+		decay := math.Pow(0.5, float64(at.Sub(r.updatedAt))/float64(halfLife))
+		r.weightedStars *= decay
+		r.weight *= decay
+	}
+	r.weightedStars += float64(stars)
+	r.weight++
+	r.count++
+	r.updatedAt = at
+}
+
+type Reputation struct {
+	Score float64
+	Count int
+}
+
+// RatingService collects two-way ratings on completed trips, up to window
+// after completion (0 means no limit), and keeps a decayed reputation score
+// for every rider and driver.
+type RatingService struct {
+	trips    *TripService
+	clock    Clock
+	halfLife time.Duration
+	window   time.Duration
+
+	mu      sync.Mutex
+	rated   map[string]Rating // trip ID + party -> rating
+	riders  map[string]*reputation
+	drivers map[string]*reputation
+	blocked map[[2]string]bool // {rider ID, driver ID} with a 1-star rating either way
+}
+
+func NewRatingService(trips *TripService, clock Clock, halfLife, window time.Duration) *RatingService {
+	return &RatingService{
+		trips:    trips,
+		clock:    clock,
+		halfLife: halfLife,
+		window:   window,
+		rated:    make(map[string]Rating),
+		riders:   make(map[string]*reputation),
+		drivers:  make(map[string]*reputation),
+		blocked:  make(map[[2]string]bool),
+	}
+}
+
+// Rate records one party's rating of the other on a completed trip.
+func (s *RatingService) Rate(tripID string, by RatingParty, stars int, comment string, tags []string) (Rating, error) {
+	if stars < 1 || stars > 5 {
+		return Rating{}, ErrInvalidStars
+	}
+	trip, err := s.trips.GetTrip(tripID)
+	if err != nil {
+		return Rating{}, err
+	}
+	if trip.State != Completed || trip.Rider == nil || trip.Driver == nil {
+		return Rating{}, ErrTripNotCompleted
+	}
+	if s.window > 0 && s.clock.Now().Sub(trip.CompletedAt) > s.window {
+		return Rating{}, ErrRatingClosed
+	}
+
+	rating := Rating{
+		TripID:  tripID,
+		By:      by,
+		Stars:   stars,
+		Comment: comment,
+		Tags:    tags,
+		At:      s.clock.Now(),
+	}
+	var ratee map[string]*reputation
+	switch by {
+	case RatedByRider:
+		rating.RaterID, rating.RateeID = trip.Rider.ID, trip.Driver.ID
+		ratee = s.drivers
+	case RatedByDriver:
+		rating.RaterID, rating.RateeID = trip.Driver.ID, trip.Rider.ID
+		ratee = s.riders
+	default:
+		return Rating{}, errors.New("unknown rating party")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := tripID + "/" + string(by)
+	if _, done := s.rated[key]; done {
+		return Rating{}, ErrAlreadyRated
+	}
+	s.rated[key] = rating
+
+	rep, ok := ratee[rating.RateeID]
+	if !ok {
+		rep = &reputation{}
+		ratee[rating.RateeID] = rep
+	}
+	rep.add(stars, rating.At, s.halfLife)
+
+	if stars == 1 && trip.Rider.ID != "" {
+		s.blocked[[2]string{trip.Rider.ID, trip.Driver.ID}] = true
+	}
+	return rating, nil
+}
+
+func (s *RatingService) RiderReputation(riderID string) Reputation {
+	return s.reputationOf(s.riders, riderID)
+}
+
+func (s *RatingService) DriverReputation(driverID string) Reputation {
+	return s.reputationOf(s.drivers, driverID)
+}
+
+// reputationOf reports the decayed average; nobody rated yet starts at 5.
+func (s *RatingService) reputationOf(reps map[string]*reputation, id string) Reputation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rep, ok := reps[id]
+	if !ok || rep.weight == 0 {
+		return Reputation{Score: 5, Count: 0}
+	}
+	return Reputation{Score: rep.weightedStars / rep.weight, Count: rep.count}
+}
+
+// Avoid reports whether the rider and driver gave each other 1 star.
+// Pass it to Dispatcher.AvoidPairs.
+func (s *RatingService) Avoid(rider *User, driver *Driver) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.blocked[[2]string{rider.ID, driver.ID}]
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+const (
+	testRatingHalfLife = 30 * 24 * time.Hour
+	testRatingWindow   = 7 * 24 * time.Hour
+)
+
+// completeTrip saves an in-progress trip of rider with driver and completes it.
+func completeTrip(t *testing.T, repo TripRepository, trips *TripService, id, riderID, driverID string) {
+	t.Helper()
+	trip := inProgressTrip(id)
+	trip.Rider = &User{ID: riderID}
+	trip.Driver = &Driver{ID: driverID}
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trips.CompleteTrip(id, StandardPricingCalculator{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRatingOnlyOnceAfterCompletion(t *testing.T) {
+	repo := NewTripRepositoryMemory()
+	clock := NewVirtualClock(testNow)
+	trips := NewTripService(repo, clock)
+	ratings := NewRatingService(trips, clock, testRatingHalfLife, testRatingWindow)
+
+	if _, err := trips.RequestTrip("open", &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ratings.Rate("open", RatedByRider, 5, "", nil); !errors.Is(err, ErrTripNotCompleted) {
+		t.Errorf("rating a requested trip: got %v, want ErrTripNotCompleted", err)
+	}
+
+	completeTrip(t, repo, trips, "t1", "r1", "d1")
+	if _, err := ratings.Rate("t1", RatedByRider, 0, "", nil); !errors.Is(err, ErrInvalidStars) {
+		t.Errorf("0 stars: got %v, want ErrInvalidStars", err)
+	}
+	rating, err := ratings.Rate("t1", RatedByRider, 4, "smooth", []string{"clean car"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rating.RaterID != "r1" || rating.RateeID != "d1" {
+		t.Errorf("rider's rating from %s of %s, want r1 of d1", rating.RaterID, rating.RateeID)
+	}
+	if _, err := ratings.Rate("t1", RatedByRider, 5, "", nil); !errors.Is(err, ErrAlreadyRated) {
+		t.Errorf("rating twice: got %v, want ErrAlreadyRated", err)
+	}
+	if _, err := ratings.Rate("t1", RatedByDriver, 5, "", nil); err != nil {
+		t.Errorf("the driver rating the same trip: %v", err)
+	}
+	if rep := ratings.DriverReputation("d1"); rep != (Reputation{Score: 4, Count: 1}) {
+		t.Errorf("driver reputation = %+v, want 4 from one rating", rep)
+	}
+}
+
+func TestRatingWindowClosesAfterCompletion(t *testing.T) {
+	repo := NewTripRepositoryMemory()
+	clock := NewVirtualClock(testNow)
+	trips := NewTripService(repo, clock)
+	ratings := NewRatingService(trips, clock, testRatingHalfLife, testRatingWindow)
+	completeTrip(t, repo, trips, "t1", "r1", "d1")
+
+	clock.Advance(testRatingWindow)
+	if _, err := ratings.Rate("t1", RatedByRider, 5, "", nil); err != nil {
+		t.Errorf("rating at the end of the window: %v", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := ratings.Rate("t1", RatedByDriver, 5, "", nil); !errors.Is(err, ErrRatingClosed) {
+		t.Errorf("rating after the window: got %v, want ErrRatingClosed", err)
+	}
+}
+
+func TestReputationDecaysWithHalfLife(t *testing.T) {
+	repo := NewTripRepositoryMemory()
+	clock := NewVirtualClock(testNow)
+	trips := NewTripService(repo, clock)
+	ratings := NewRatingService(trips, clock, testRatingHalfLife, 0)
+	completeTrip(t, repo, trips, "t1", "r1", "d1")
+	completeTrip(t, repo, trips, "t2", "r2", "d1")
+
+	if _, err := ratings.Rate("t1", RatedByRider, 1, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(testRatingHalfLife)
+	if _, err := ratings.Rate("t2", RatedByRider, 5, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	// The older rating now weighs half: (1×0.5 + 5) / 1.5.
+	rep := ratings.DriverReputation("d1")
+	if want := 5.5 / 1.5; math.Abs(rep.Score-want) > 1e-9 || rep.Count != 2 {
+		t.Errorf("reputation = %+v, want %.3f from 2 ratings", rep, want)
+	}
+	if rep := ratings.RiderReputation("r9"); rep != (Reputation{Score: 5}) {
+		t.Errorf("unrated rider = %+v, want 5 from none", rep)
+	}
+}
+
+func TestOneStarKeepsPairApart(t *testing.T) {
+	repo := NewTripRepositoryMemory()
+	clock := NewVirtualClock(testNow)
+	trips := NewTripService(repo, clock)
+	ratings := NewRatingService(trips, clock, testRatingHalfLife, testRatingWindow)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	dispatcher.AvoidPairs(ratings.Avoid)
+
+	completeTrip(t, repo, trips, "t1", "r1", "d1")
+	if _, err := ratings.Rate("t1", RatedByDriver, 1, "rude", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !ratings.Avoid(&User{ID: "r1"}, &Driver{ID: "d1"}) || ratings.Avoid(&User{ID: "r2"}, &Driver{ID: "d1"}) {
+		t.Fatal("Avoid must block r1/d1 only")
+	}
+
+	if err := dispatcher.GoOnline(&Driver{ID: "d1", Vehicle: Vehicle{Class: Economy}}, testPickup); err != nil {
+		t.Fatal(err)
+	}
+	for _, rider := range []string{"r1", "r2"} {
+		if _, err := trips.RequestTrip("next-"+rider, &User{ID: rider}, testPickup, testDropoff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := trips.AssignNearestDriver("next-r1", dispatcher, 5, 5); !errors.Is(err, ErrNoDriverAvailable) {
+		t.Errorf("matching r1 with the driver they rated 1 star: got %v, want ErrNoDriverAvailable", err)
+	}
+	trip, err := trips.AssignNearestDriver("next-r2", dispatcher, 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trip.Driver.ID != "d1" {
+		t.Errorf("r2 matched with %s, want d1", trip.Driver.ID)
+	}
+}
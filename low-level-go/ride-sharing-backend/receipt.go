@@ -0,0 +1,104 @@
+package main
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io"
+	"text/template"
+	"time"
+)
+
+//go:embed templates/receipt.txt templates/receipt.html
+var receiptTemplates embed.FS
+
+var (
+	receiptText = template.Must(template.New("receipt.txt").Funcs(receiptFuncs).ParseFS(receiptTemplates, "templates/receipt.txt"))
+	receiptHTML = htmltemplate.Must(htmltemplate.New("receipt.html").Funcs(receiptFuncs).ParseFS(receiptTemplates, "templates/receipt.html"))
+)
+
+var receiptFuncs = map[string]any{
+	"timestamp": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+}
+
+// RouteSummary describes how far and how long the trip went. The distance
+// is the one the fare was priced on, as recorded at completion, and Source
+// says where it was measured; Pings counts the GPS points behind a metered
+// distance.
+type RouteSummary struct {
+	DistanceMiles float64
+	Duration      time.Duration
+	Source        string
+	Pings         int
+}
+
+// Receipt is everything support needs to explain a fare line by line.
+type Receipt struct {
+	TripID       string
+	State        TripState
+	RiderName    string
+	DriverName   string
+	Vehicle      string
+	Pickup       *Location
+	Dropoff      *Location
+	RequestedAt  time.Time
+	StartedAt    time.Time
+	CompletedAt  time.Time
+	Route        RouteSummary
+	Lines        []FareLine
+	Total        Money
+	Payment      PaymentStatus
+	Cancellation *Cancellation
+}
+
+func NewReceipt(trip *Trip) Receipt {
+	receipt := Receipt{
+		TripID:       trip.ID,
+		State:        trip.State,
+		Pickup:       trip.PickupLocation,
+		Dropoff:      trip.DropoffLocation,
+		RequestedAt:  trip.RequestedAt,
+		StartedAt:    trip.StartedAt,
+		CompletedAt:  trip.CompletedAt,
+		Route:        summarizeRoute(trip),
+		Lines:        trip.FareBreakdown,
+		Total:        trip.Fare,
+		Payment:      trip.Payment,
+		Cancellation: trip.Cancellation,
+	}
+	if trip.Rider != nil {
+		receipt.RiderName = trip.Rider.Name
+	}
+	if driver := trip.Driver; driver != nil {
+		receipt.DriverName = driver.Name
+		if v := driver.Vehicle; v.Plate != "" {
+			receipt.Vehicle = v.Make + " " + v.Model + " (" + v.Plate + ")"
+		}
+	}
+	return receipt
+}
+
+func summarizeRoute(trip *Trip) RouteSummary {
+	var summary RouteSummary
+	if !trip.StartedAt.IsZero() && !trip.CompletedAt.IsZero() {
+		summary.Duration = trip.CompletedAt.Sub(trip.StartedAt).Round(time.Second)
+	}
+	summary.DistanceMiles = trip.BilledMiles
+	summary.Source = trip.DistanceSource
+	if summary.Source == DistanceFromGPS {
+		summary.Pings = len(DefaultRouteFilter().Clean(trip.Route))
+	}
+	return summary
+}
+
+func (r Receipt) WriteText(w io.Writer) error {
+	return receiptText.Execute(w, r)
+}
+
+func (r Receipt) WriteHTML(w io.Writer) error {
+	return receiptHTML.Execute(w, r)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiptItemizesCompletedTrip(t *testing.T) {
+	repo := NewTripRepositoryMemory()
+	clock := NewVirtualClock(testNow)
+	trips := NewTripService(repo, clock)
+	trip := inProgressTrip("t1")
+	trip.Rider = &User{ID: "r1", Name: "Rita"}
+	trip.Driver = &Driver{ID: "d1", Name: "Dee", Vehicle: Vehicle{Make: "Toyota", Model: "Prius", Plate: "7ABC123"}}
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(15 * time.Minute)
+	trip, err := trips.CompleteTrip("t1", StandardPricingCalculator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt := NewReceipt(trip)
+	if receipt.Route.DistanceMiles != trip.BilledMiles || receipt.Route.Source != DistanceStraightLine {
+		t.Errorf("route = %+v, want the billed %.2f mi measured in a %s", receipt.Route, trip.BilledMiles, DistanceStraightLine)
+	}
+	var text strings.Builder
+	if err := receipt.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Rider:    Rita",
+		"Driver:   Dee, Toyota Prius (7ABC123)",
+		"Completed 2026-10-13 12:15 UTC",
+		fmt.Sprintf("Route     %.1f mi in 15m0s (%s)", trip.BilledMiles, DistanceStraightLine),
+		fmt.Sprintf("%-32s %12s", "TOTAL", trip.Fare),
+	}
+	for _, line := range trip.FareBreakdown {
+		want = append(want, fmt.Sprintf("%-32s %12s", line.Label, line.Amount))
+	}
+	for _, line := range want {
+		if !strings.Contains(text.String(), line+"\n") {
+			t.Errorf("receipt is missing %q:\n%s", line, text.String())
+		}
+	}
+}
+
+func TestReceiptFormatsFareCurrency(t *testing.T) {
+	tests := []struct {
+		lines []FareLine
+		total Money
+		want  []string
+	}{
+		{
+			lines: []FareLine{{Kind: FareBase, Label: "Base fare", Amount: NewMoney(123456, USD)}},
+			total: NewMoney(123456, USD),
+			want:  []string{"$1,234.56"},
+		},
+		{
+			lines: []FareLine{
+				{Kind: FareBase, Label: "Base fare", Amount: NewMoney(1500, JPY)},
+				{Kind: FareDiscount, Label: "Promo WELCOME", Amount: NewMoney(-300, JPY)},
+			},
+			total: NewMoney(1200, JPY),
+			want:  []string{"¥1,500", "-¥300", "¥1,200"},
+		},
+		{
+			lines: []FareLine{{Kind: FareBase, Label: "Base fare", Amount: NewMoney(2500, KWD)}},
+			total: NewMoney(2500, KWD),
+			want:  []string{"KWD 2.500"},
+		},
+	}
+	for _, tc := range tests {
+		receipt := Receipt{TripID: "t1", State: Completed, Pickup: testPickup, Dropoff: testDropoff, Lines: tc.lines, Total: tc.total}
+		var text, html strings.Builder
+		if err := receipt.WriteText(&text); err != nil {
+			t.Fatal(err)
+		}
+		if err := receipt.WriteHTML(&html); err != nil {
+			t.Fatal(err)
+		}
+		for _, amount := range tc.want {
+			if !strings.Contains(text.String(), amount) {
+				t.Errorf("text receipt in %s is missing %q:\n%s", tc.total.Currency, amount, text.String())
+			}
+			if !strings.Contains(html.String(), amount) {
+				t.Errorf("HTML receipt in %s is missing %q", tc.total.Currency, amount)
+			}
+		}
+	}
+}
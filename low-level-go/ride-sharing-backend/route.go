@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type LocationPing struct {
+	Location  *Location
+	Timestamp time.Time
+}
+
+const (
+	// maxPlausibleSpeedMPH rejects pings that would need the car to teleport.
+	maxPlausibleSpeedMPH = 90.0
+	// minMovementMiles (~16 m) treats smaller moves as GPS jitter.
+	minMovementMiles = 0.01
+)
+
+// RouteFilter cleans a raw GPS trace before it is measured.
+type RouteFilter struct {
+	MaxSpeedMPH      float64
+	MinMovementMiles float64
+	Distance         DistanceProvider
+}
+
+func DefaultRouteFilter() RouteFilter {
+	return RouteFilter{
+		MaxSpeedMPH:      maxPlausibleSpeedMPH,
+		MinMovementMiles: minMovementMiles,
+		Distance:         HaversineDistance{},
+	}
+}
+
+// Clean sorts pings by time and drops duplicates, jitter below
+// MinMovementMiles and jumps faster than MaxSpeedMPH from the last kept ping.
+func (f RouteFilter) Clean(route []LocationPing) []LocationPing {
+	pings := make([]LocationPing, len(route))
+	copy(pings, route)
+	sort.SliceStable(pings, func(i, j int) bool {
+		return pings[i].Timestamp.Before(pings[j].Timestamp)
+	})
+
+	var kept []LocationPing
+	for _, ping := range pings {
+		if len(kept) == 0 {
+			kept = append(kept, ping)
+			continue
+		}
+		last := kept[len(kept)-1]
+		elapsed := ping.Timestamp.Sub(last.Timestamp)
+		if elapsed <= 0 {
+			continue
+		}
+		miles := f.Distance.DistanceMiles(last.Location, ping.Location)
+		if miles < f.MinMovementMiles {
+			continue
+		}
+		if miles/elapsed.Hours() > f.MaxSpeedMPH {
+			continue
+		}
+		kept = append(kept, ping)
+	}
+	return kept
+}
+
+// Length is the driven distance along the cleaned route, in miles.
+func (f RouteFilter) Length(route []LocationPing) float64 {
+	pings := f.Clean(route)
+	total := 0.0
+	for i := 1; i < len(pings); i++ {
+		total += f.Distance.DistanceMiles(pings[i-1].Location, pings[i].Location)
+	}
+	return total
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+var (
+	ErrOffRoadGraph = errors.New("location is too far from the road graph")
+	ErrNoRoute      = errors.New("no route between locations")
+)
+
+const (
+	// maxSnapMiles is how far a location may be from its nearest road node.
+	maxSnapMiles = 0.5
+	// accessSpeedMPH is assumed for the leg between a location and its node.
+	accessSpeedMPH = 10.0
+	// routeCacheSize bounds the cache of recent routes; pricing asks for the
+	// distance and the duration of the same route back to back.
+	routeCacheSize = 1024
+)
+
+// Route is a drivable path between two locations.
+type Route struct {
+	DistanceMiles   float64
+	DurationMinutes float64
+	Polyline        []Location
+	OnRoads         bool // false for the straight-line fallback
+}
+
+type roadEdge struct {
+	to      int
+	miles   float64
+	minutes float64
+}
+
+// RoadGraph is a directed road network. Node locations are bucketed in the
+// same grid as GeoIndex so snapping only looks at nearby cells.
+type RoadGraph struct {
+	nodes    []Location
+	edges    [][]roadEdge
+	cells    map[geoCell][]int
+	maxSpeed float64
+}
+
+// roadGraphFile is the JSON layout of a road graph. Edges are two-way
+// unless oneway is set; their length is the great-circle distance between
+// the nodes unless distance_miles is given.
+type roadGraphFile struct {
+	Nodes []struct {
+		ID        string  `json:"id"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"nodes"`
+	Edges []struct {
+		From          string  `json:"from"`
+		To            string  `json:"to"`
+		SpeedMPH      float64 `json:"speed_mph"`
+		DistanceMiles float64 `json:"distance_miles"`
+		OneWay        bool    `json:"oneway"`
+	} `json:"edges"`
+}
+
+func ParseRoadGraph(data []byte) (*RoadGraph, error) {
+	var file roadGraphFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse road graph: %w", err)
+	}
+
+	graph := &RoadGraph{cells: make(map[geoCell][]int)}
+	ids := make(map[string]int, len(file.Nodes))
+	for _, node := range file.Nodes {
+		if _, dup := ids[node.ID]; dup {
+			return nil, fmt.Errorf("parse road graph: duplicate node %q", node.ID)
+		}
+		ids[node.ID] = len(graph.nodes)
+		loc := Location{Latitude: node.Latitude, Longitude: node.Longitude}
+		cell := cellFor(&loc)
+		graph.cells[cell] = append(graph.cells[cell], len(graph.nodes))
+		graph.nodes = append(graph.nodes, loc)
+	}
+	graph.edges = make([][]roadEdge, len(graph.nodes))
+
+	for _, edge := range file.Edges {
+		from, ok := ids[edge.From]
+		if !ok {
+			return nil, fmt.Errorf("parse road graph: edge from unknown node %q", edge.From)
+		}
+		to, ok := ids[edge.To]
+		if !ok {
+			return nil, fmt.Errorf("parse road graph: edge to unknown node %q", edge.To)
+		}
+		if edge.SpeedMPH <= 0 {
+			return nil, fmt.Errorf("parse road graph: edge %s-%s needs a positive speed_mph", edge.From, edge.To)
+		}
+		miles := edge.DistanceMiles
+		if miles <= 0 {
+			miles = calculateDistance(&graph.nodes[from], &graph.nodes[to])
+		}
+		graph.addEdge(from, to, miles, edge.SpeedMPH)
+		if !edge.OneWay {
+			graph.addEdge(to, from, miles, edge.SpeedMPH)
+		}
+	}
+	return graph, nil
+}
+
+func LoadRoadGraph(path string) (*RoadGraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRoadGraph(data)
+}
+
+func (g *RoadGraph) addEdge(from, to int, miles, speedMPH float64) {
+	g.edges[from] = append(g.edges[from], roadEdge{
+		to:      to,
+		miles:   miles,
+		minutes: miles / speedMPH * 60,
+	})
+	g.maxSpeed = math.Max(g.maxSpeed, speedMPH)
+}
+
+// Snap returns the node closest to loc within maxSnapMiles.
+func (g *RoadGraph) Snap(loc *Location) (int, error) {
+	latSpan := maxSnapMiles / 69.0
+	lonSpan := maxSnapMiles / (69.0 * math.Max(math.Cos(toRadians(loc.Latitude)), 0.01))
+	minCell := cellFor(&Location{Latitude: loc.Latitude - latSpan, Longitude: loc.Longitude - lonSpan})
+	maxCell := cellFor(&Location{Latitude: loc.Latitude + latSpan, Longitude: loc.Longitude + lonSpan})
+
+	best, bestMiles := -1, math.Inf(1)
+	for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+		for lon := minCell.lon; lon <= maxCell.lon; lon++ {
+			for _, node := range g.cells[geoCell{lat: lat, lon: lon}] {
+				if miles := calculateDistance(loc, &g.nodes[node]); miles < bestMiles {
+					best, bestMiles = node, miles
+				}
+			}
+		}
+	}
+	if best < 0 || bestMiles > maxSnapMiles {
+		return -1, ErrOffRoadGraph
+	}
+	return best, nil
+}
+
+// Route snaps both ends to the graph and runs A* for the fastest path. The
+// heuristic is the straight-line distance at the graph's top speed, which
+// never overestimates, so the route found is the fastest one.
+func (g *RoadGraph) Route(from, to *Location) (Route, error) {
+	start, err := g.Snap(from)
+	if err != nil {
+		return Route{}, err
+	}
+	goal, err := g.Snap(to)
+	if err != nil {
+		return Route{}, err
+	}
+
+	heuristic := func(node int) float64 {
+		if g.maxSpeed == 0 {
+			return 0
+		}
+		return calculateDistance(&g.nodes[node], &g.nodes[goal]) / g.maxSpeed * 60
+	}
+
+	minutes := map[int]float64{start: 0}
+	miles := map[int]float64{start: 0}
+	previous := map[int]int{}
+	closed := map[int]bool{}
+	open := &routeQueue{{node: start, priority: heuristic(start)}}
+
+	for open.Len() > 0 {
+		current := heap.Pop(open).(routeQueueItem).node
+		if current == goal {
+			break
+		}
+		if closed[current] {
+			continue
+		}
+		closed[current] = true
+
+		for _, edge := range g.edges[current] {
+			if closed[edge.to] {
+				continue
+			}
+			candidate := minutes[current] + edge.minutes
+			if known, seen := minutes[edge.to]; seen && candidate >= known {
+				continue
+			}
+			minutes[edge.to] = candidate
+			miles[edge.to] = miles[current] + edge.miles
+			previous[edge.to] = current
+			heap.Push(open, routeQueueItem{node: edge.to, priority: candidate + heuristic(edge.to)})
+		}
+	}
+	if _, reached := minutes[goal]; !reached {
+		return Route{}, ErrNoRoute
+	}
+
+	var path []Location
+	for node := goal; ; node = previous[node] {
+		path = append(path, g.nodes[node])
+		if node == start {
+			break
+		}
+	}
+	polyline := make([]Location, 0, len(path)+2)
+	polyline = append(polyline, *from)
+	for i := len(path) - 1; i >= 0; i-- {
+		polyline = append(polyline, path[i])
+	}
+	polyline = append(polyline, *to)
+
+	access := calculateDistance(from, &g.nodes[start]) + calculateDistance(&g.nodes[goal], to)
+	return Route{
+		DistanceMiles:   miles[goal] + access,
+		DurationMinutes: minutes[goal] + estimateDuration(access, accessSpeedMPH),
+		Polyline:        polyline,
+	}, nil
+}
+
+type routeQueueItem struct {
+	node     int
+	priority float64
+}
+
+// routeQueue is a min-heap of A* frontier nodes by estimated total minutes.
+type routeQueue []routeQueueItem
+
+func (q routeQueue) Len() int           { return len(q) }
+func (q routeQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q routeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x any)        { *q = append(*q, x.(routeQueueItem)) }
+func (q *routeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Router answers distance, ETA and route queries over a road graph, and
+// falls back to the straight-line estimate when there is no graph or a
+// location can't be routed. It implements DistanceProvider and ETAProvider.
+type Router struct {
+	Graph    *RoadGraph
+	Fallback DistanceProvider
+	SpeedMPH float64 // fallback speed
+
+	mu    sync.Mutex
+	cache map[[2]Location]Route
+}
+
+func NewRouter(graph *RoadGraph) *Router {
+	return &Router{
+		Graph:    graph,
+		Fallback: HaversineDistance{},
+		SpeedMPH: defaultSpeedMPH,
+		cache:    make(map[[2]Location]Route),
+	}
+}
+
+// Route returns the road route, or a straight line between the two points
+// at the fallback speed.
+func (r *Router) Route(from, to *Location) Route {
+	key := [2]Location{*from, *to}
+	r.mu.Lock()
+	route, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok {
+		return route
+	}
+
+	route, err := Route{}, ErrNoRoute
+	if r.Graph != nil {
+		route, err = r.Graph.Route(from, to)
+		route.OnRoads = err == nil
+	}
+	if err != nil {
+		miles := r.Fallback.DistanceMiles(from, to)
+		route = Route{
+			DistanceMiles:   miles,
+			DurationMinutes: estimateDuration(miles, r.SpeedMPH),
+			Polyline:        []Location{*from, *to},
+		}
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= routeCacheSize {
+		clear(r.cache)
+	}
+	r.cache[key] = route
+	r.mu.Unlock()
+	return route
+}
+
+func (r *Router) DistanceMiles(from, to *Location) float64 {
+	return r.Route(from, to).DistanceMiles
+}
+
+func (r *Router) DistanceSource(from, to *Location) string {
+	if r.Route(from, to).OnRoads {
+		return DistanceFromRoadGraph
+	}
+	return DistanceStraightLine
+}
+
+func (r *Router) DurationMinutes(from, to *Location) float64 {
+	return r.Route(from, to).DurationMinutes
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRoadGraph is a square A-B-C-D with a slow street straight from A to B,
+// so the fastest way round goes A-D-C-B, and an island E with no roads.
+const testRoadGraph = `{
+  "nodes": [
+    {"id": "A", "latitude": 37.7700, "longitude": -122.4200},
+    {"id": "B", "latitude": 37.7700, "longitude": -122.4100},
+    {"id": "C", "latitude": 37.7800, "longitude": -122.4100},
+    {"id": "D", "latitude": 37.7800, "longitude": -122.4200},
+    {"id": "E", "latitude": 37.7750, "longitude": -122.4000}
+  ],
+  "edges": [
+    {"from": "A", "to": "B", "speed_mph": 5},
+    {"from": "A", "to": "D", "speed_mph": 30},
+    {"from": "D", "to": "C", "speed_mph": 30},
+    {"from": "C", "to": "B", "speed_mph": 30}
+  ]
+}`
+
+var (
+	nodeA = &Location{Latitude: 37.7700, Longitude: -122.4200}
+	nodeB = &Location{Latitude: 37.7700, Longitude: -122.4100}
+	nodeD = &Location{Latitude: 37.7800, Longitude: -122.4200}
+	nodeC = &Location{Latitude: 37.7800, Longitude: -122.4100}
+	nodeE = &Location{Latitude: 37.7750, Longitude: -122.4000}
+)
+
+func TestRoadGraphFastestRoute(t *testing.T) {
+	graph, err := ParseRoadGraph([]byte(testRoadGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, err := graph.Route(nodeA, nodeB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Location{*nodeA, *nodeA, *nodeD, *nodeC, *nodeB, *nodeB}
+	if len(route.Polyline) != len(want) {
+		t.Fatalf("polyline %v, want A-D-C-B", route.Polyline)
+	}
+	for i := range want {
+		if route.Polyline[i] != want[i] {
+			t.Fatalf("polyline %v, want A-D-C-B", route.Polyline)
+		}
+	}
+	miles := calculateDistance(nodeA, nodeD) + calculateDistance(nodeD, nodeC) + calculateDistance(nodeC, nodeB)
+	if math.Abs(route.DistanceMiles-miles) > 1e-9 || math.Abs(route.DurationMinutes-miles/30*60) > 1e-9 {
+		t.Errorf("route = %.3f mi in %.2f min, want %.3f mi at 30 mph", route.DistanceMiles, route.DurationMinutes, miles)
+	}
+}
+
+func TestRoadGraphUnroutable(t *testing.T) {
+	graph, err := ParseRoadGraph([]byte(testRoadGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.Route(nodeA, nodeE); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("route to an island: got %v, want ErrNoRoute", err)
+	}
+	farAway := &Location{Latitude: 40.7128, Longitude: -74.0060}
+	if _, err := graph.Route(nodeA, farAway); !errors.Is(err, ErrOffRoadGraph) {
+		t.Errorf("route off the graph: got %v, want ErrOffRoadGraph", err)
+	}
+
+	// The router falls back to a straight line for both.
+	router := NewRouter(graph)
+	route := router.Route(nodeA, nodeE)
+	if route.OnRoads || route.DistanceMiles != calculateDistance(nodeA, nodeE) {
+		t.Errorf("fallback route = %+v, want the straight line", route)
+	}
+	if got := router.DistanceSource(nodeA, nodeE); got != DistanceStraightLine {
+		t.Errorf("source = %q, want %q", got, DistanceStraightLine)
+	}
+	if got := router.DistanceSource(nodeA, nodeB); got != DistanceFromRoadGraph {
+		t.Errorf("source = %q, want %q", got, DistanceFromRoadGraph)
+	}
+}
+
+func TestRouterWithoutGraphGoesStraight(t *testing.T) {
+	router := NewRouter(nil)
+	route := router.Route(testPickup, testDropoff)
+	miles := HaversineDistance{}.DistanceMiles(testPickup, testDropoff)
+	if route.OnRoads || route.DistanceMiles != miles || len(route.Polyline) != 2 {
+		t.Errorf("route = %+v, want a straight line of %.3f mi", route, miles)
+	}
+	if got, want := router.DurationMinutes(testPickup, testDropoff), estimateDuration(miles, defaultSpeedMPH); got != want {
+		t.Errorf("duration = %.2f min, want %.2f at the fallback speed", got, want)
+	}
+}
+
+func TestLoadRoadGraphErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadRoadGraph(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want os.ErrNotExist", err)
+	}
+
+	tests := map[string]string{
+		"malformed JSON": `{"nodes": [`,
+		"duplicate node": `{"nodes": [{"id": "A"}, {"id": "A"}]}`,
+		"unknown from":   `{"nodes": [{"id": "A"}], "edges": [{"from": "Z", "to": "A", "speed_mph": 30}]}`,
+		"unknown to":     `{"nodes": [{"id": "A"}], "edges": [{"from": "A", "to": "Z", "speed_mph": 30}]}`,
+		"no speed":       `{"nodes": [{"id": "A"}, {"id": "B"}], "edges": [{"from": "A", "to": "B"}]}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, "graph.json")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadRoadGraph(path); err == nil {
+				t.Error("LoadRoadGraph accepted the graph")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Notifier tells the rider what happened to their trip.
+type Notifier interface {
+	Notify(trip *Trip, message string)
+}
+
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(trip *Trip, message string) {
+	log.Printf("trip %s: %s", trip.ID, message)
+}
+
+var ErrPickupInPast = errors.New("scheduled pickup time has already passed")
+
+type SchedulerConfig struct {
+	// LeadTime is how long before PickupAt the trip enters matching.
+	LeadTime time.Duration
+	// RetryInterval is the wait between failed matching attempts.
+	RetryInterval time.Duration
+	// MatchDeadline is how long after PickupAt matching is given up.
+	MatchDeadline time.Duration
+}
+
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		LeadTime:      15 * time.Minute,
+		RetryInterval: 30 * time.Second,
+		MatchDeadline: 10 * time.Minute,
+	}
+}
+
+// Scheduler releases scheduled trips into matching ahead of their pickup
+// time and keeps retrying until a driver is found or the deadline passes,
+// at which point the trip is cancelled and the rider notified.
+//
+// Tick reads time only from the injected Clock; give the TripService the
+// same Clock so the transitions it stamps line up with the schedule.
+type Scheduler struct {
+	trips    *TripService
+	match    func(tripID string) error
+	notifier Notifier
+	clock    Clock
+	config   SchedulerConfig
+
+	mu      sync.Mutex
+	pending map[string]time.Time // trip ID -> next matching attempt
+}
+
+func NewScheduler(trips *TripService, match func(tripID string) error, notifier Notifier, clock Clock, config SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		trips:    trips,
+		match:    match,
+		notifier: notifier,
+		clock:    clock,
+		config:   config,
+		pending:  make(map[string]time.Time),
+	}
+}
+
+// Schedule hands a saved SCHEDULED trip to the scheduler. A pickup time that
+// has already passed is rejected with ErrPickupInPast.
+func (s *Scheduler) Schedule(trip *Trip) error {
+	if trip.State != Scheduled {
+		return ErrInvalidTransition{From: trip.State, To: Scheduled}
+	}
+	if trip.PickupAt.Before(s.clock.Now()) {
+		return ErrPickupInPast
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[trip.ID] = trip.PickupAt.Add(-s.config.LeadTime)
+	return nil
+}
+
+// Tick handles every trip whose next attempt is due.
+func (s *Scheduler) Tick() {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	var due []string
+	for id, next := range s.pending {
+		if !now.Before(next) {
+			due = append(due, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range due {
+		next, done := s.attempt(id, now)
+		s.mu.Lock()
+		if done {
+			delete(s.pending, id)
+		} else {
+			s.pending[id] = next
+		}
+		s.mu.Unlock()
+	}
+}
+
+// attempt runs one matching round for the trip and reports when to try
+// again, or done once the trip no longer needs the scheduler.
+func (s *Scheduler) attempt(id string, now time.Time) (next time.Time, done bool) {
+	trip, err := s.trips.GetTrip(id)
+	if err != nil {
+		return time.Time{}, true
+	}
+
+	if trip.State == Scheduled {
+		if trip, err = s.trips.ReleaseForMatching(id); err != nil {
+			log.Printf("scheduler: releasing trip %s: %v", id, err)
+			return now.Add(s.config.RetryInterval), false
+		}
+	}
+	if trip.State != Requested {
+		return time.Time{}, true
+	}
+
+	err = s.match(id)
+	if err == nil {
+		if trip, err := s.trips.GetTrip(id); err == nil {
+			s.notifier.Notify(trip, "driver assigned")
+		}
+		return time.Time{}, true
+	}
+	var invalid ErrInvalidTransition
+	if errors.As(err, &invalid) {
+		return time.Time{}, true
+	}
+
+	if now.After(trip.PickupAt.Add(s.config.MatchDeadline)) {
+		trip, err := s.trips.CancelTrip(id, CancelledBySystem, "no driver found", nil)
+		if err != nil {
+			log.Printf("scheduler: cancelling trip %s: %v", id, err)
+			return now.Add(s.config.RetryInterval), false
+		}
+		s.notifier.Notify(trip, "no driver found, trip cancelled")
+		return time.Time{}, true
+	}
+	return now.Add(s.config.RetryInterval), false
+}
+
+// Run calls Tick every interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Tick()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct{ messages []string }
+
+func (n *recordingNotifier) Notify(trip *Trip, message string) {
+	n.messages = append(n.messages, message)
+}
+
+func TestSchedulerReleasesOnTheInjectedClock(t *testing.T) {
+	trips, clock := newTestService(t)
+	notifier := &recordingNotifier{}
+	errNoDriver := errors.New("no driver")
+	scheduler := NewScheduler(trips, func(string) error { return errNoDriver }, notifier, clock, DefaultSchedulerConfig())
+
+	late := NewScheduledTrip("late", &User{ID: "r1"}, testPickup, testDropoff, clock.Now(), clock.Now().Add(-time.Minute))
+	if err := scheduler.Schedule(late); !errors.Is(err, ErrPickupInPast) {
+		t.Errorf("past pickup: got %v, want ErrPickupInPast", err)
+	}
+
+	pickupAt := clock.Now().Add(time.Hour)
+	trip, err := trips.SubmitTrip(NewScheduledTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, clock.Now(), pickupAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Schedule(trip); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Hour - DefaultSchedulerConfig().LeadTime - time.Second)
+	scheduler.Tick()
+	if trip, _ := trips.GetTrip("t1"); trip.State != Scheduled {
+		t.Fatalf("released early, in state %s", trip.State)
+	}
+	clock.Advance(time.Second)
+	scheduler.Tick()
+	trip, _ = trips.GetTrip("t1")
+	if trip.State != Requested || !trip.History[len(trip.History)-1].At.Equal(clock.Now()) {
+		t.Fatalf("trip %s released at %v, want REQUESTED at %v", trip.State, trip.History, clock.Now())
+	}
+
+	clock.Advance(DefaultSchedulerConfig().LeadTime + DefaultSchedulerConfig().MatchDeadline + time.Second)
+	scheduler.Tick()
+	if trip, _ := trips.GetTrip("t1"); trip.State != Cancelled {
+		t.Errorf("trip past its match deadline is %s, want CANCELLED", trip.State)
+	}
+	if len(notifier.messages) != 1 {
+		t.Errorf("rider notified %q, want one cancellation notice", notifier.messages)
+	}
+}
@@ -0,0 +1,1307 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMatchCandidates  = 5
+	defaultMatchRadiusMiles = 5.0
+)
+
+// Quoter is implemented by calculators that lock a price into the trip at
+// request time, like SurgePricingCalculator.
+type Quoter interface {
+	Quote(trip *Trip) FareBreakdown
+}
+
+// ServerDeps wires the services behind the HTTP API.
+type ServerDeps struct {
+	Trips        *TripService
+	Dispatcher   *Dispatcher
+	Scheduler    *Scheduler
+	Offers       *OfferManager
+	Ratings      *RatingService
+	Settlement   *SettlementService
+	Promos       *PromoEngine
+	Geofence     *Geofence
+	Router       *Router
+	EventLog     *TripRepositoryEventLog // optional; the trips' repository when set
+	Tracker      *TripTracker
+	TrackingAuth TrackingAuth
+	Anomalies    *AnomalyDetector
+	Pricing      PriceCalculator
+	Cancellation CancellationPolicy
+	Clock        Clock // SystemClock when nil
+}
+
+// Server exposes the trip lifecycle over HTTP/JSON.
+type Server struct {
+	trips        *TripService
+	dispatcher   *Dispatcher
+	scheduler    *Scheduler
+	offers       *OfferManager
+	ratings      *RatingService
+	settlement   *SettlementService
+	promos       *PromoEngine
+	geofence     *Geofence
+	router       *Router
+	eventLog     *TripRepositoryEventLog
+	tracker      *TripTracker
+	trackingAuth TrackingAuth
+	anomalies    *AnomalyDetector
+	pricing      PriceCalculator
+	cancellation CancellationPolicy
+	clock        Clock
+}
+
+func NewServer(deps ServerDeps) *Server {
+	clock := deps.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &Server{
+		trips:        deps.Trips,
+		dispatcher:   deps.Dispatcher,
+		scheduler:    deps.Scheduler,
+		offers:       deps.Offers,
+		ratings:      deps.Ratings,
+		settlement:   deps.Settlement,
+		promos:       deps.Promos,
+		geofence:     deps.Geofence,
+		router:       deps.Router,
+		eventLog:     deps.EventLog,
+		tracker:      deps.Tracker,
+		trackingAuth: deps.TrackingAuth,
+		anomalies:    deps.Anomalies,
+		pricing:      deps.Pricing,
+		cancellation: deps.Cancellation,
+		clock:        clock,
+	}
+}
+
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /quotes", s.handleQuote)
+	mux.HandleFunc("POST /pools/quote", s.handlePoolQuote)
+	mux.HandleFunc("POST /trips", s.handleRequestTrip)
+	mux.HandleFunc("GET /trips/{id}", s.handleGetTrip)
+	mux.HandleFunc("POST /trips/{id}/assign", s.handleAssign)
+	mux.HandleFunc("POST /trips/{id}/dispatch", s.handleDispatch)
+	mux.HandleFunc("POST /offers/{id}/accept", s.handleAcceptOffer)
+	mux.HandleFunc("POST /offers/{id}/decline", s.handleDeclineOffer)
+	mux.HandleFunc("POST /trips/{id}/start", s.handleStart)
+	mux.HandleFunc("POST /trips/{id}/complete", s.handleComplete)
+	mux.HandleFunc("POST /trips/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("POST /trips/{id}/payment", s.handleSettle)
+	mux.HandleFunc("POST /trips/{id}/locations", s.handleRecordLocation)
+	mux.HandleFunc("POST /trips/{id}/stops", s.handleAddStop)
+	mux.HandleFunc("PUT /trips/{id}/destination", s.handleChangeDestination)
+	mux.HandleFunc("POST /trips/{id}/stops/arrive", s.handleArriveAtStop)
+	mux.HandleFunc("POST /trips/{id}/stops/depart", s.handleDepartStop)
+	mux.HandleFunc("POST /trips/{id}/review", s.handleReview)
+	mux.HandleFunc("GET /reviews", s.handleReviewQueue)
+	mux.HandleFunc("GET /trips/{id}/receipt", s.handleReceipt)
+	mux.HandleFunc("GET /trips/{id}/eta", s.handleETA)
+	mux.HandleFunc("GET /trips/{id}/events", s.handleTripEvents)
+	mux.HandleFunc("GET /trips/{id}/track", s.handleTrack)
+	mux.HandleFunc("GET /drivers/{id}/trips", s.handleDriverTrips)
+	mux.HandleFunc("POST /drivers/{id}/trips/{trip}/arrive", s.handleArriveAtPickup)
+	mux.HandleFunc("POST /drivers/{id}/trips/{trip}/cancel", s.handleDriverCancel)
+	mux.HandleFunc("GET /drivers/{id}/statements/{week}", s.handleDriverStatement)
+	mux.HandleFunc("POST /trips/{id}/ratings", s.handleRate)
+	mux.HandleFunc("GET /riders/{id}/reputation", s.handleRiderReputation)
+	mux.HandleFunc("GET /drivers/{id}/reputation", s.handleDriverReputation)
+	mux.HandleFunc("POST /drivers/{id}/online", s.handleDriverOnline)
+	mux.HandleFunc("POST /drivers/{id}/offline", s.handleDriverOffline)
+	mux.HandleFunc("PUT /drivers/{id}/location", s.handleDriverLocation)
+	mux.HandleFunc("GET /zones", s.handleZones)
+	return mux
+}
+
+type locationJSON struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (l *locationJSON) toLocation() *Location {
+	if l == nil {
+		return nil
+	}
+	return &Location{Latitude: l.Latitude, Longitude: l.Longitude}
+}
+
+func locationToJSON(loc *Location) *locationJSON {
+	if loc == nil {
+		return nil
+	}
+	return &locationJSON{Latitude: loc.Latitude, Longitude: loc.Longitude}
+}
+
+type tripRequest struct {
+	ID           string         `json:"id"`
+	RiderID      string         `json:"rider_id"`
+	RiderName    string         `json:"rider_name"`
+	Pickup       *locationJSON  `json:"pickup"`
+	Dropoff      *locationJSON  `json:"dropoff"`
+	Stops        []locationJSON `json:"stops"`
+	VehicleClass VehicleClass   `json:"vehicle_class"`
+	PickupAt     *time.Time     `json:"pickup_at"`
+	PromoCode    string         `json:"promo_code"`
+}
+
+// toTrip builds the requested trip as of now.
+func (req tripRequest) toTrip(now time.Time) (*Trip, error) {
+	if req.Pickup == nil || req.Dropoff == nil {
+		return nil, errors.New("pickup and dropoff are required")
+	}
+	id := req.ID
+	if id == "" {
+		id = newTripID()
+	}
+	rider := &User{ID: req.RiderID, Name: req.RiderName}
+	trip := NewTrip(id, rider, req.Pickup.toLocation(), req.Dropoff.toLocation(), now)
+	if req.PickupAt != nil {
+		trip = NewScheduledTrip(id, rider, req.Pickup.toLocation(), req.Dropoff.toLocation(), now, *req.PickupAt)
+	}
+	if req.VehicleClass != "" {
+		if !req.VehicleClass.Valid() {
+			return nil, fmt.Errorf("%w %q", ErrUnknownVehicleClass, req.VehicleClass)
+		}
+		trip.VehicleClass = req.VehicleClass
+	}
+	trip.PromoCode = req.PromoCode
+	for i := range req.Stops {
+		if err := trip.AddStop(req.Stops[i].toLocation(), trip.RequestedAt); err != nil {
+			return nil, err
+		}
+	}
+	return trip, nil
+}
+
+// moneyJSON carries amounts as exact decimal strings so clients never
+// round-trip a fare through a float.
+type moneyJSON struct {
+	Amount   string   `json:"amount"`
+	Currency Currency `json:"currency"`
+	Display  string   `json:"display"`
+}
+
+func moneyToJSON(m Money) moneyJSON {
+	return moneyJSON{Amount: m.Decimal(), Currency: m.Currency, Display: m.String()}
+}
+
+type fareLineJSON struct {
+	Kind   FareLineKind `json:"kind"`
+	Label  string       `json:"label"`
+	Amount moneyJSON    `json:"amount"`
+}
+
+func fareLinesToJSON(lines []FareLine) []fareLineJSON {
+	var out []fareLineJSON
+	for _, line := range lines {
+		out = append(out, fareLineJSON{Kind: line.Kind, Label: line.Label, Amount: moneyToJSON(line.Amount)})
+	}
+	return out
+}
+
+type cancellationJSON struct {
+	By     CancellationParty `json:"by"`
+	Reason string            `json:"reason"`
+	At     time.Time         `json:"at"`
+	Fee    moneyJSON         `json:"fee"`
+}
+
+type tripResponse struct {
+	ID                 string                  `json:"id"`
+	RiderID            string                  `json:"rider_id,omitempty"`
+	RiderName          string                  `json:"rider_name"`
+	DriverID           string                  `json:"driver_id,omitempty"`
+	DriverName         string                  `json:"driver_name,omitempty"`
+	Pickup             *locationJSON           `json:"pickup"`
+	Dropoff            *locationJSON           `json:"dropoff"`
+	Stops              []stopJSON              `json:"stops,omitempty"`
+	DestinationChanges []destinationChangeJSON `json:"destination_changes,omitempty"`
+	PickupZones        []string                `json:"pickup_zones,omitempty"`
+	DropoffZones       []string                `json:"dropoff_zones,omitempty"`
+	VehicleClass       VehicleClass            `json:"vehicle_class"`
+	State              TripState               `json:"state"`
+	Fare               moneyJSON               `json:"fare"`
+	FareBreakdown      []fareLineJSON          `json:"fare_breakdown,omitempty"`
+	SurgeMultiplier    float64                 `json:"surge_multiplier,omitempty"`
+	PromoCode          string                  `json:"promo_code,omitempty"`
+	RequestedAt        time.Time               `json:"requested_at"`
+	PickupAt           *time.Time              `json:"pickup_at,omitempty"`
+	StartedAt          *time.Time              `json:"started_at,omitempty"`
+	CompletedAt        *time.Time              `json:"completed_at,omitempty"`
+	Cancellation       *cancellationJSON       `json:"cancellation,omitempty"`
+	PaymentStatus      PaymentStatus           `json:"payment_status,omitempty"`
+	Review             *reviewJSON             `json:"review,omitempty"`
+	Version            int                     `json:"version"`
+	// TrackingToken is only returned to the rider who requested the trip.
+	TrackingToken string `json:"tracking_token,omitempty"`
+}
+
+func tripToResponse(trip *Trip) tripResponse {
+	resp := tripResponse{
+		ID:              trip.ID,
+		Pickup:          locationToJSON(trip.PickupLocation),
+		Dropoff:         locationToJSON(trip.DropoffLocation),
+		PickupZones:     trip.PickupZones,
+		DropoffZones:    trip.DropoffZones,
+		VehicleClass:    trip.VehicleClass,
+		State:           trip.State,
+		Fare:            moneyToJSON(trip.Fare),
+		SurgeMultiplier: trip.SurgeMultiplier,
+		PromoCode:       trip.PromoCode,
+		RequestedAt:     trip.RequestedAt,
+		PickupAt:        optionalTime(trip.PickupAt),
+		StartedAt:       optionalTime(trip.StartedAt),
+		CompletedAt:     optionalTime(trip.CompletedAt),
+		PaymentStatus:   trip.Payment,
+		Version:         trip.Version,
+	}
+	if trip.Rider != nil {
+		resp.RiderID = trip.Rider.ID
+		resp.RiderName = trip.Rider.Name
+	}
+	if trip.Driver != nil {
+		resp.DriverID = trip.Driver.ID
+		resp.DriverName = trip.Driver.Name
+	}
+	resp.FareBreakdown = fareLinesToJSON(trip.FareBreakdown)
+	if c := trip.Cancellation; c != nil {
+		resp.Cancellation = &cancellationJSON{By: c.By, Reason: c.Reason, At: c.At, Fee: moneyToJSON(c.Fee)}
+	}
+	for _, stop := range trip.Waypoints {
+		resp.Stops = append(resp.Stops, stopJSON{
+			Location:   locationToJSON(stop.Location),
+			ArrivedAt:  optionalTime(stop.ArrivedAt),
+			DepartedAt: optionalTime(stop.DepartedAt),
+		})
+	}
+	for _, change := range trip.DestinationChanges {
+		resp.DestinationChanges = append(resp.DestinationChanges, destinationChangeJSON{
+			From: locationToJSON(change.From),
+			To:   locationToJSON(change.To),
+			At:   change.At,
+		})
+	}
+	if review := trip.Review; review != nil {
+		resp.Review = &reviewJSON{
+			Status:     review.Status,
+			Reviewer:   review.Reviewer,
+			Note:       review.Note,
+			ReviewedAt: optionalTime(review.ReviewedAt),
+		}
+		for _, flag := range review.Flags {
+			resp.Review.Flags = append(resp.Review.Flags, tripFlagJSON{Kind: flag.Kind, Detail: flag.Detail, At: flag.At})
+		}
+	}
+	return resp
+}
+
+type stopJSON struct {
+	Location   *locationJSON `json:"location"`
+	ArrivedAt  *time.Time    `json:"arrived_at,omitempty"`
+	DepartedAt *time.Time    `json:"departed_at,omitempty"`
+}
+
+type destinationChangeJSON struct {
+	From *locationJSON `json:"from"`
+	To   *locationJSON `json:"to"`
+	At   time.Time     `json:"at"`
+}
+
+type tripFlagJSON struct {
+	Kind   AnomalyKind `json:"kind"`
+	Detail string      `json:"detail"`
+	At     time.Time   `json:"at"`
+}
+
+type reviewJSON struct {
+	Status     ReviewStatus   `json:"status"`
+	Flags      []tripFlagJSON `json:"flags"`
+	Reviewer   string         `json:"reviewer,omitempty"`
+	Note       string         `json:"note,omitempty"`
+	ReviewedAt *time.Time     `json:"reviewed_at,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (s *Server) handleQuote(w http.ResponseWriter, r *http.Request) {
+	var req tripRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := req.toTrip(s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.geofence != nil {
+		if err := s.geofence.Admit(trip); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
+	fare := s.pricing.CalculateFare(trip)
+	if err := fare.Err(); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"fare":           moneyToJSON(fare.Total()),
+		"fare_breakdown": fareLinesToJSON(fare.Lines),
+		"vehicle_class":  trip.VehicleClass,
+	})
+}
+
+type poolQuoteRequest struct {
+	Riders []tripRequest `json:"riders"`
+	// MaxDetourRatio is DefaultMaxDetourRatio when left out; 0 allows no
+	// detour at all.
+	MaxDetourRatio *float64 `json:"max_detour_ratio"`
+}
+
+type poolFareJSON struct {
+	RiderID     string    `json:"rider_id"`
+	RiddenMiles float64   `json:"ridden_miles"`
+	SharedMiles float64   `json:"shared_miles"`
+	Fare        moneyJSON `json:"fare"`
+}
+
+type poolStopJSON struct {
+	Kind     StopKind      `json:"kind"`
+	RiderID  string        `json:"rider_id"`
+	Location *locationJSON `json:"location"`
+}
+
+// handlePoolQuote routes riders through one shared vehicle in request order
+// and quotes each rider's split fare. A rider whose detour limit can't be
+// met is rejected with 422.
+func (s *Server) handlePoolQuote(w http.ResponseWriter, r *http.Request) {
+	var req poolQuoteRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Riders) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("riders are required"))
+		return
+	}
+	maxDetourRatio := DefaultMaxDetourRatio
+	if req.MaxDetourRatio != nil {
+		maxDetourRatio = *req.MaxDetourRatio
+	}
+	if maxDetourRatio < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("max_detour_ratio must not be negative"))
+		return
+	}
+
+	pool := NewPooledTrip(newTripID(), nil, maxDetourRatio)
+	for _, rider := range req.Riders {
+		trip, err := rider.toTrip(s.clock.Now())
+		if err == nil && trip.Rider.ID == "" {
+			err = errors.New("rider_id is required")
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if s.geofence != nil {
+			if err := s.geofence.Admit(trip); err != nil {
+				writeServiceError(w, err)
+				return
+			}
+		}
+		if err := pool.AddRider(trip.Rider, trip.PickupLocation, trip.DropoffLocation); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("rider %s: %w", trip.Rider.ID, err))
+			return
+		}
+	}
+
+	var stops []poolStopJSON
+	for _, stop := range pool.Stops {
+		stops = append(stops, poolStopJSON{Kind: stop.Kind, RiderID: stop.Rider.ID, Location: locationToJSON(stop.Location)})
+	}
+	split, err := pool.SplitFares(s.pricing, s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	var fares []poolFareJSON
+	for _, fare := range split {
+		fares = append(fares, poolFareJSON{
+			RiderID:     fare.Rider.ID,
+			RiddenMiles: fare.RiddenMiles,
+			SharedMiles: fare.SharedMiles,
+			Fare:        moneyToJSON(fare.Fare),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"stops": stops, "fares": fares})
+}
+
+func (s *Server) handleRequestTrip(w http.ResponseWriter, r *http.Request) {
+	var req tripRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := req.toTrip(s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if trip.PromoCode != "" {
+		if err := s.promos.Validate(trip.PromoCode, trip); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+	if quoter, ok := s.pricing.(Quoter); ok {
+		quoter.Quote(trip)
+	}
+
+	trip, err = s.trips.SubmitTrip(trip)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if trip.State == Scheduled {
+		if err := s.scheduler.Schedule(trip); err != nil {
+			if _, cancelErr := s.trips.CancelTrip(trip.ID, CancelledBySystem, "could not schedule: "+err.Error(), nil); cancelErr != nil {
+				log.Printf("cancelling unschedulable trip %s: %v", trip.ID, cancelErr)
+			}
+			writeServiceError(w, err)
+			return
+		}
+	} else {
+		s.dispatcher.TrackRequest(trip.ID)
+	}
+	resp := tripToResponse(trip)
+	resp.TrackingToken = s.trackingAuth.Token(trip)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) handleGetTrip(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.GetTrip(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+type assignRequest struct {
+	RadiusMiles float64 `json:"radius_miles"`
+}
+
+func (s *Server) handleAssign(w http.ResponseWriter, r *http.Request) {
+	req := assignRequest{RadiusMiles: defaultMatchRadiusMiles}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.AssignNearestDriver(r.PathValue("id"), s.dispatcher, defaultMatchCandidates, req.RadiusMiles)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleDispatch(w http.ResponseWriter, r *http.Request) {
+	if err := s.offers.Dispatch(r.PathValue("id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type offerAnswer struct {
+	DriverID string `json:"driver_id"`
+}
+
+func (s *Server) handleAcceptOffer(w http.ResponseWriter, r *http.Request) {
+	var req offerAnswer
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.offers.Accept(r.PathValue("id"), req.DriverID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleDeclineOffer(w http.ResponseWriter, r *http.Request) {
+	var req offerAnswer
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.offers.Decline(r.PathValue("id"), req.DriverID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleArriveAtPickup(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.ArriveAtPickup(r.PathValue("trip"), r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.StartTrip(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	s.dispatcher.StartDriverTrip(trip.Driver.ID)
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.CompleteTrip(r.PathValue("id"), s.pricing)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	s.releaseDriver(trip)
+	trip = s.settle(trip)
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+type cancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handleCancel cancels on behalf of the rider. Drivers cancel through
+// handleDriverCancel, so the party comes from the route, not the body.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	var req cancelRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.CancelTrip(r.PathValue("id"), CancelledByRider, req.Reason, s.cancellation)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	s.cancelled(w, trip)
+}
+
+func (s *Server) handleDriverCancel(w http.ResponseWriter, r *http.Request) {
+	var req cancelRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.CancelTripByDriver(r.PathValue("trip"), r.PathValue("id"), req.Reason, s.cancellation)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	s.cancelled(w, trip)
+}
+
+func (s *Server) cancelled(w http.ResponseWriter, trip *Trip) {
+	s.dispatcher.DropRequest(trip.ID)
+	s.releaseDriver(trip)
+	if trip.Fare.IsPositive() {
+		trip = s.settle(trip)
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+type ratingRequest struct {
+	By      RatingParty `json:"by"`
+	Stars   int         `json:"stars"`
+	Comment string      `json:"comment"`
+	Tags    []string    `json:"tags"`
+}
+
+type ratingResponse struct {
+	TripID  string      `json:"trip_id"`
+	By      RatingParty `json:"by"`
+	RaterID string      `json:"rater_id"`
+	RateeID string      `json:"ratee_id"`
+	Stars   int         `json:"stars"`
+	Comment string      `json:"comment,omitempty"`
+	Tags    []string    `json:"tags,omitempty"`
+	At      time.Time   `json:"at"`
+}
+
+func (s *Server) handleRate(w http.ResponseWriter, r *http.Request) {
+	var req ratingRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	rating, err := s.ratings.Rate(r.PathValue("id"), req.By, req.Stars, req.Comment, req.Tags)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ratingResponse{
+		TripID:  rating.TripID,
+		By:      rating.By,
+		RaterID: rating.RaterID,
+		RateeID: rating.RateeID,
+		Stars:   rating.Stars,
+		Comment: rating.Comment,
+		Tags:    rating.Tags,
+		At:      rating.At,
+	})
+}
+
+type reputationResponse struct {
+	Score float64 `json:"score"`
+	Count int     `json:"count"`
+}
+
+func (s *Server) handleRiderReputation(w http.ResponseWriter, r *http.Request) {
+	rep := s.ratings.RiderReputation(r.PathValue("id"))
+	writeJSON(w, http.StatusOK, reputationResponse{Score: rep.Score, Count: rep.Count})
+}
+
+func (s *Server) handleDriverReputation(w http.ResponseWriter, r *http.Request) {
+	rep := s.ratings.DriverReputation(r.PathValue("id"))
+	writeJSON(w, http.StatusOK, reputationResponse{Score: rep.Score, Count: rep.Count})
+}
+
+type vehicleJSON struct {
+	Make  string       `json:"make"`
+	Model string       `json:"model"`
+	Plate string       `json:"plate"`
+	Class VehicleClass `json:"class"`
+}
+
+type driverOnlineRequest struct {
+	Name     string        `json:"name"`
+	Vehicle  vehicleJSON   `json:"vehicle"`
+	Location *locationJSON `json:"location"`
+}
+
+func (s *Server) handleDriverOnline(w http.ResponseWriter, r *http.Request) {
+	var req driverOnlineRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Location == nil {
+		writeError(w, http.StatusBadRequest, errors.New("location is required"))
+		return
+	}
+	if req.Vehicle.Class == "" {
+		req.Vehicle.Class = Economy
+	}
+	if !req.Vehicle.Class.Valid() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w %q", ErrUnknownVehicleClass, req.Vehicle.Class))
+		return
+	}
+	driver := &Driver{
+		ID:   r.PathValue("id"),
+		Name: req.Name,
+		Vehicle: Vehicle{
+			Make:  req.Vehicle.Make,
+			Model: req.Vehicle.Model,
+			Plate: req.Vehicle.Plate,
+			Class: req.Vehicle.Class,
+		},
+	}
+	if err := s.dispatcher.GoOnline(driver, req.Location.toLocation()); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDriverOffline(w http.ResponseWriter, r *http.Request) {
+	if err := s.dispatcher.GoOffline(r.PathValue("id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDriverLocation(w http.ResponseWriter, r *http.Request) {
+	var req locationJSON
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.dispatcher.Heartbeat(r.PathValue("id"), req.toLocation()); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	s.tracker.DriverMoved(r.PathValue("id"), req.toLocation())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type etaResponse struct {
+	TripID          string         `json:"trip_id"`
+	Target          string         `json:"target"`
+	DurationMinutes float64        `json:"duration_minutes"`
+	DistanceMiles   float64        `json:"distance_miles"`
+	Polyline        []locationJSON `json:"polyline"`
+}
+
+// handleETA routes the assigned driver to the pickup, or the car to its
+// next stop or the dropoff once the trip is in progress.
+func (s *Server) handleETA(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.GetTrip(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	resp, err := s.eta(trip)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) eta(trip *Trip) (etaResponse, error) {
+	var target string
+	var destination *Location
+	switch trip.State {
+	case DriverAssigned:
+		target, destination = "pickup", trip.PickupLocation
+	case InProgress:
+		target, destination = trip.NextDestination()
+	default:
+		return etaResponse{}, fmt.Errorf("no ETA for a %s trip: %w", trip.State, ErrNoDriverLocation)
+	}
+	position, err := s.dispatcher.DriverLocation(trip.Driver.ID)
+	if err != nil {
+		return etaResponse{}, err
+	}
+	if position == nil {
+		return etaResponse{}, ErrNoDriverLocation
+	}
+
+	route := s.router.Route(position, destination)
+	resp := etaResponse{
+		TripID:          trip.ID,
+		Target:          target,
+		DurationMinutes: route.DurationMinutes,
+		DistanceMiles:   route.DistanceMiles,
+	}
+	for i := range route.Polyline {
+		resp.Polyline = append(resp.Polyline, *locationToJSON(&route.Polyline[i]))
+	}
+	return resp, nil
+}
+
+type trackingUpdateJSON struct {
+	TripID         string        `json:"trip_id"`
+	State          TripState     `json:"state"`
+	DriverLocation *locationJSON `json:"driver_location,omitempty"`
+	Target         string        `json:"target,omitempty"`
+	ETAMinutes     float64       `json:"eta_minutes,omitempty"`
+	DistanceMiles  float64       `json:"distance_miles,omitempty"`
+	At             time.Time     `json:"at"`
+}
+
+// trackingKeepAlive is how often an idle stream sends a comment, so
+// proxies don't close it.
+const trackingKeepAlive = 15 * time.Second
+
+// handleTrack streams the trip's driver position and ETA as Server-Sent
+// Events to its rider, who authenticates with the tracking token from
+// POST /trips (?token= or "Authorization: Bearer"). The stream ends after
+// the update that moves the trip to COMPLETED or CANCELLED.
+func (s *Server) handleTrack(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.GetTrip(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if err := s.trackingAuth.Verify(trip, token, s.clock.Now()); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	sub, err := s.tracker.Subscribe(trip)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	defer sub.Close()
+	// The trip may have ended between the read above and Subscribe.
+	if latest, err := s.trips.GetTrip(trip.ID); err == nil && latest.Version > trip.Version {
+		s.tracker.TripSaved(latest)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(trackingKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case update, open := <-sub.Updates():
+			if !open {
+				fmt.Fprint(w, "event: closed\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			data, _ := json.Marshal(trackingUpdateJSON{
+				TripID:         update.TripID,
+				State:          update.State,
+				DriverLocation: locationToJSON(update.DriverLocation),
+				Target:         update.Target,
+				ETAMinutes:     update.ETAMinutes,
+				DistanceMiles:  update.DistanceMiles,
+				At:             update.At,
+			})
+			fmt.Fprintf(w, "event: update\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+var errNoEventLog = errors.New("event log is not enabled")
+
+// handleTripEvents lists the trip's events as stored in the log.
+func (s *Server) handleTripEvents(w http.ResponseWriter, r *http.Request) {
+	if s.eventLog == nil {
+		writeError(w, http.StatusNotImplemented, errNoEventLog)
+		return
+	}
+	events, err := s.eventLog.Events(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// handleDriverTrips lists the driver's trips for ?date=YYYY-MM-DD, today by
+// default, from the driver-day projection.
+func (s *Server) handleDriverTrips(w http.ResponseWriter, r *http.Request) {
+	if s.eventLog == nil {
+		writeError(w, http.StatusNotImplemented, errNoEventLog)
+		return
+	}
+	driverID := r.PathValue("id")
+	var trips []*Trip
+	if date := r.URL.Query().Get("date"); date != "" {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		trips = s.eventLog.DriverTripsOn(driverID, date)
+	} else {
+		trips = s.eventLog.DriverTripsToday(driverID)
+	}
+
+	resp := []tripResponse{}
+	for _, trip := range trips {
+		resp = append(resp, tripToResponse(trip))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type zoneJSON struct {
+	Name      string     `json:"name"`
+	Kind      ZoneKind   `json:"kind"`
+	Surcharge *moneyJSON `json:"surcharge,omitempty"`
+}
+
+// handleZones reports whether ?latitude=&longitude= is serviced and which
+// named zones contain it.
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request) {
+	lat, latErr := strconv.ParseFloat(r.URL.Query().Get("latitude"), 64)
+	lng, lngErr := strconv.ParseFloat(r.URL.Query().Get("longitude"), 64)
+	if latErr != nil || lngErr != nil {
+		writeError(w, http.StatusBadRequest, errors.New("latitude and longitude are required"))
+		return
+	}
+	loc := &Location{Latitude: lat, Longitude: lng}
+
+	geofence := s.geofence
+	if geofence == nil {
+		geofence = &Geofence{}
+	}
+	zones := []zoneJSON{}
+	for _, zone := range geofence.ZonesAt(loc) {
+		z := zoneJSON{Name: zone.Name, Kind: zone.Kind}
+		if zone.Surcharge.IsPositive() {
+			surcharge := moneyToJSON(zone.Surcharge)
+			z.Surcharge = &surcharge
+		}
+		zones = append(zones, z)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"in_service_area": geofence.InServiceArea(loc),
+		"zones":           zones,
+	})
+}
+
+// settle charges the rider. A failed charge doesn't fail the request: the
+// trip is already finished and stays PAYMENT_PENDING until a retry succeeds.
+func (s *Server) settle(trip *Trip) *Trip {
+	settled, err := s.settlement.Settle(trip.ID)
+	if err != nil {
+		log.Printf("settling trip %s: %v", trip.ID, err)
+		if latest, err := s.trips.GetTrip(trip.ID); err == nil {
+			return latest
+		}
+		return trip
+	}
+	return settled
+}
+
+func (s *Server) handleSettle(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.settlement.Settle(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+type locationPingRequest struct {
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+	At        *time.Time `json:"at"`
+}
+
+// handleRecordLocation takes a GPS ping from the driver's app during the
+// ride; at defaults to now.
+func (s *Server) handleRecordLocation(w http.ResponseWriter, r *http.Request) {
+	var req locationPingRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	at := s.clock.Now()
+	if req.At != nil {
+		at = *req.At
+	}
+	loc := &Location{Latitude: req.Latitude, Longitude: req.Longitude}
+	if _, err := s.trips.RecordLocation(r.PathValue("id"), loc, at); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAddStop(w http.ResponseWriter, r *http.Request) {
+	var req locationJSON
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.AddStop(r.PathValue("id"), req.toLocation(), s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleChangeDestination(w http.ResponseWriter, r *http.Request) {
+	var req locationJSON
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.ChangeDestination(r.PathValue("id"), req.toLocation(), s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleArriveAtStop(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.ArriveAtStop(r.PathValue("id"), s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+func (s *Server) handleDepartStop(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.DepartStop(r.PathValue("id"), s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+type reviewRequest struct {
+	Status   ReviewStatus `json:"status"`
+	Reviewer string       `json:"reviewer"`
+	Note     string       `json:"note"`
+}
+
+// handleReview records a reviewer's decision on a held trip. A cleared trip
+// that has finished is settled right away.
+func (s *Server) handleReview(w http.ResponseWriter, r *http.Request) {
+	var req reviewRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	trip, err := s.trips.ResolveReview(r.PathValue("id"), req.Status, req.Reviewer, req.Note, s.clock.Now())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if trip.Review.Status == ReviewCleared && trip.State.IsTerminal() && trip.Fare.IsPositive() {
+		trip = s.settle(trip)
+	}
+	writeJSON(w, http.StatusOK, tripToResponse(trip))
+}
+
+// handleReviewQueue lists the trips held for review, oldest first.
+func (s *Server) handleReviewQueue(w http.ResponseWriter, r *http.Request) {
+	trips := []tripResponse{}
+	for _, id := range s.anomalies.Held() {
+		if trip, err := s.trips.GetTrip(id); err == nil {
+			trips = append(trips, tripToResponse(trip))
+		}
+	}
+	writeJSON(w, http.StatusOK, trips)
+}
+
+type routeSummaryJSON struct {
+	DistanceMiles   float64 `json:"distance_miles"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Source          string  `json:"source,omitempty"`
+	Pings           int     `json:"pings"`
+	Metered         bool    `json:"metered"`
+}
+
+type receiptResponse struct {
+	TripID       string            `json:"trip_id"`
+	State        TripState         `json:"state"`
+	RiderName    string            `json:"rider_name,omitempty"`
+	DriverName   string            `json:"driver_name,omitempty"`
+	Vehicle      string            `json:"vehicle,omitempty"`
+	Pickup       *locationJSON     `json:"pickup"`
+	Dropoff      *locationJSON     `json:"dropoff"`
+	RequestedAt  time.Time         `json:"requested_at"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	Route        routeSummaryJSON  `json:"route"`
+	Lines        []fareLineJSON    `json:"lines"`
+	Total        moneyJSON         `json:"total"`
+	Payment      PaymentStatus     `json:"payment,omitempty"`
+	Cancellation *cancellationJSON `json:"cancellation,omitempty"`
+}
+
+func receiptToResponse(receipt Receipt) receiptResponse {
+	resp := receiptResponse{
+		TripID:      receipt.TripID,
+		State:       receipt.State,
+		RiderName:   receipt.RiderName,
+		DriverName:  receipt.DriverName,
+		Vehicle:     receipt.Vehicle,
+		Pickup:      locationToJSON(receipt.Pickup),
+		Dropoff:     locationToJSON(receipt.Dropoff),
+		RequestedAt: receipt.RequestedAt,
+		StartedAt:   optionalTime(receipt.StartedAt),
+		CompletedAt: optionalTime(receipt.CompletedAt),
+		Route: routeSummaryJSON{
+			DistanceMiles:   receipt.Route.DistanceMiles,
+			DurationSeconds: receipt.Route.Duration.Seconds(),
+			Source:          receipt.Route.Source,
+			Pings:           receipt.Route.Pings,
+			Metered:         receipt.Route.Source == DistanceFromGPS,
+		},
+		Lines:   fareLinesToJSON(receipt.Lines),
+		Total:   moneyToJSON(receipt.Total),
+		Payment: receipt.Payment,
+	}
+	if resp.Lines == nil {
+		resp.Lines = []fareLineJSON{}
+	}
+	if c := receipt.Cancellation; c != nil {
+		resp.Cancellation = &cancellationJSON{By: c.By, Reason: c.Reason, At: c.At, Fee: moneyToJSON(c.Fee)}
+	}
+	return resp
+}
+
+// handleReceipt renders the itemized receipt of a finished trip as JSON
+// (the default), plain text or HTML, chosen by ?format=.
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	trip, err := s.trips.GetTrip(r.PathValue("id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if !trip.State.IsTerminal() {
+		writeError(w, http.StatusConflict, errors.New("trip is not finished"))
+		return
+	}
+
+	receipt := NewReceipt(trip)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, receiptToResponse(receipt))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = receipt.WriteText(w)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = receipt.WriteHTML(w)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown receipt format %q", format))
+	}
+	if err != nil {
+		log.Printf("rendering receipt for trip %s: %v", trip.ID, err)
+	}
+}
+
+type payoutLineJSON struct {
+	TripID string    `json:"trip_id"`
+	At     time.Time `json:"at"`
+	Amount moneyJSON `json:"amount"`
+}
+
+type statementResponse struct {
+	DriverID string           `json:"driver_id"`
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Lines    []payoutLineJSON `json:"lines"`
+	Total    moneyJSON        `json:"total"`
+}
+
+// handleDriverStatement serves the payout statement for the week starting
+// on the given date (YYYY-MM-DD, UTC).
+func (s *Server) handleDriverStatement(w http.ResponseWriter, r *http.Request) {
+	weekStart, err := time.Parse(time.DateOnly, r.PathValue("week"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	statement, err := s.settlement.WeeklyStatement(r.PathValue("id"), weekStart)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	resp := statementResponse{
+		DriverID: statement.DriverID,
+		From:     statement.From,
+		To:       statement.To,
+		Lines:    []payoutLineJSON{},
+		Total:    moneyToJSON(statement.Total),
+	}
+	for _, line := range statement.Lines {
+		resp.Lines = append(resp.Lines, payoutLineJSON{TripID: line.TripID, At: line.At, Amount: moneyToJSON(line.Amount)})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) releaseDriver(trip *Trip) {
+	if trip.Driver != nil {
+		s.dispatcher.ReleaseDriver(trip.Driver.ID)
+	}
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeServiceError maps domain errors onto HTTP status codes.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var invalid ErrInvalidTransition
+	switch {
+	case errors.As(err, &invalid),
+		errors.Is(err, ErrVersionConflict),
+		errors.Is(err, ErrTripExists),
+		errors.Is(err, ErrDriverUnavailable),
+		errors.Is(err, ErrDriverOnTrip),
+		errors.Is(err, ErrOfferClosed),
+		errors.Is(err, ErrDispatching),
+		errors.Is(err, ErrTripNotCompleted),
+		errors.Is(err, ErrAlreadyRated),
+		errors.Is(err, ErrRatingClosed),
+		errors.Is(err, ErrNothingToCharge),
+		errors.Is(err, ErrNoDriverLocation),
+		errors.Is(err, ErrTripNotInProgress),
+		errors.Is(err, ErrTripRejected),
+		errors.Is(err, ErrNotOnHold),
+		errors.Is(err, ErrTooManyStops),
+		errors.Is(err, ErrRouteLocked),
+		errors.Is(err, ErrNoStopAhead),
+		errors.Is(err, ErrNotAtStop),
+		errors.Is(err, ErrStillAtStop),
+		errors.Is(err, ErrStopsNotReady),
+		errors.Is(err, ErrNotEnRoute),
+		errors.Is(err, ErrRideInProgress):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrNotTripDriver):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, ErrTripNotFound),
+		errors.Is(err, ErrDriverNotFound),
+		errors.Is(err, ErrOfferNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrInvalidStars),
+		errors.Is(err, ErrInvalidReviewStatus),
+		errors.Is(err, ErrInvalidCancellationParty):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrOutsideServiceArea),
+		errors.Is(err, ErrPickupInPast):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, ErrPaymentFailed):
+		writeError(w, http.StatusPaymentRequired, err)
+	case errors.Is(err, ErrNoDriverAvailable):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func newTripID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
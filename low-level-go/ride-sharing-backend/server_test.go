@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer wires a server over in-memory services, priced by the
+// standard calculator, on a virtual clock.
+func newTestServer(t *testing.T) (http.Handler, *VirtualClock) {
+	t.Helper()
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	dispatcher.ReadRequestsFrom(trips.GetTrip)
+	match := func(tripID string) error {
+		_, err := trips.AssignNearestDriver(tripID, dispatcher, defaultMatchCandidates, defaultMatchRadiusMiles)
+		return err
+	}
+	router := NewRouter(nil)
+	tracker := NewTripTracker(router, dispatcher, clock)
+	trips.OnTripSaved(tracker.TripSaved)
+	server := NewServer(ServerDeps{
+		Trips:        trips,
+		Dispatcher:   dispatcher,
+		Scheduler:    NewScheduler(trips, match, &recordingNotifier{}, clock, DefaultSchedulerConfig()),
+		Offers:       NewOfferManager(trips, dispatcher, &recordingSender{}, &recordingNotifier{}, clock, DefaultOfferConfig()),
+		Ratings:      NewRatingService(trips, clock, 30*24*time.Hour, 7*24*time.Hour),
+		Settlement:   NewSettlementService(trips, NewFakeGateway(), NewLedger(), clock, DefaultSettlementConfig()),
+		Promos:       NewPromoEngine(clock),
+		Router:       router,
+		Tracker:      tracker,
+		TrackingAuth: RandomTrackingAuth(),
+		Pricing:      StandardPricingCalculator{},
+		Cancellation: DefaultCancellationPolicy(USD),
+		Clock:        clock,
+	})
+	return server.Routes(), clock
+}
+
+func serve(t *testing.T, handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// serveTrip expects status and decodes the trip in the response.
+func serveTrip(t *testing.T, handler http.Handler, method, path, body string, status int) tripResponse {
+	t.Helper()
+	w := serve(t, handler, method, path, body)
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, status, w.Body)
+	}
+	var trip tripResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &trip); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return trip
+}
+
+const testTripRequest = `{"id": "t1", "rider_id": "r1",
+  "pickup": {"latitude": 37.7749, "longitude": -122.4194},
+  "dropoff": {"latitude": 37.8044, "longitude": -122.2712}}`
+
+func driverOnline(t *testing.T, handler http.Handler, id string) {
+	t.Helper()
+	body := fmt.Sprintf(`{"name": "Dee", "location": {"latitude": %v, "longitude": %v}}`, testPickup.Latitude, testPickup.Longitude)
+	if w := serve(t, handler, "POST", "/drivers/"+id+"/online", body); w.Code != http.StatusNoContent {
+		t.Fatalf("driver online: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestServerTripLifecycle(t *testing.T) {
+	handler, _ := newTestServer(t)
+	driverOnline(t, handler, "d1")
+
+	trip := serveTrip(t, handler, "POST", "/trips", testTripRequest, http.StatusCreated)
+	if trip.State != Requested || trip.TrackingToken == "" {
+		t.Fatalf("requested trip in %s with token %q", trip.State, trip.TrackingToken)
+	}
+	trip = serveTrip(t, handler, "POST", "/trips/t1/assign", "", http.StatusOK)
+	if trip.State != DriverAssigned || trip.DriverID != "d1" {
+		t.Fatalf("assigned trip in %s to %q", trip.State, trip.DriverID)
+	}
+	serveTrip(t, handler, "POST", "/trips/t1/start", "", http.StatusOK)
+	trip = serveTrip(t, handler, "POST", "/trips/t1/complete", "", http.StatusOK)
+	if trip.State != Completed || trip.Fare.Currency != USD || trip.Fare.Amount == "0.00" {
+		t.Errorf("completed trip in %s for %+v", trip.State, trip.Fare)
+	}
+	if trip.PaymentStatus != PaymentSettled {
+		t.Errorf("payment = %s, want %s", trip.PaymentStatus, PaymentSettled)
+	}
+	if got := serveTrip(t, handler, "GET", "/trips/t1", "", http.StatusOK); got.Version != trip.Version {
+		t.Errorf("GET returned version %d, want %d", got.Version, trip.Version)
+	}
+
+	// The driver is free for the next trip.
+	serveTrip(t, handler, "POST", "/trips", strings.Replace(testTripRequest, `"t1"`, `"t2"`, 1), http.StatusCreated)
+	if trip := serveTrip(t, handler, "POST", "/trips/t2/assign", "", http.StatusOK); trip.DriverID != "d1" {
+		t.Errorf("next trip assigned to %q, want d1", trip.DriverID)
+	}
+}
+
+func TestServerCancel(t *testing.T) {
+	handler, _ := newTestServer(t)
+	serveTrip(t, handler, "POST", "/trips", testTripRequest, http.StatusCreated)
+
+	trip := serveTrip(t, handler, "POST", "/trips/t1/cancel", `{"reason": "changed plans"}`, http.StatusOK)
+	if trip.State != Cancelled || trip.Cancellation == nil || trip.Cancellation.By != CancelledByRider {
+		t.Fatalf("cancelled trip in %s: %+v", trip.State, trip.Cancellation)
+	}
+	if trip.Fare.Amount != "0.00" {
+		t.Errorf("free cancellation charged %s", trip.Fare.Display)
+	}
+	if w := serve(t, handler, "POST", "/trips/t1/cancel", ""); w.Code != http.StatusConflict {
+		t.Errorf("cancelling twice: status %d, want 409", w.Code)
+	}
+}
+
+func TestServerRejectsInvalidTransitions(t *testing.T) {
+	handler, _ := newTestServer(t)
+	serveTrip(t, handler, "POST", "/trips", testTripRequest, http.StatusCreated)
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"POST", "/trips/t1/start", http.StatusConflict},
+		{"POST", "/trips/t1/complete", http.StatusConflict},
+		{"POST", "/trips", http.StatusConflict}, // same ID again
+		{"POST", "/trips/t1/assign", http.StatusServiceUnavailable},
+		{"POST", "/trips/nope/start", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		body := ""
+		if tc.path == "/trips" {
+			body = testTripRequest
+		}
+		if w := serve(t, handler, tc.method, tc.path, body); w.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tc.method, tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestServerValidatesRequests(t *testing.T) {
+	handler, _ := newTestServer(t)
+	tests := map[string]struct {
+		path, body string
+	}{
+		"malformed body":          {"/trips", `{"rider_id": `},
+		"missing dropoff":         {"/trips", `{"rider_id": "r1", "pickup": {"latitude": 37.7, "longitude": -122.4}}`},
+		"unknown vehicle class":   {"/trips", strings.Replace(testTripRequest, `"rider_id"`, `"vehicle_class": "LIMO", "rider_id"`, 1)},
+		"quote with bad class":    {"/quotes", strings.Replace(testTripRequest, `"rider_id"`, `"vehicle_class": "economy", "rider_id"`, 1)},
+		"driver without location": {"/drivers/d1/online", `{"name": "Dee"}`},
+		"driver in unknown class": {"/drivers/d1/online", `{"vehicle": {"class": "LIMO"}, "location": {"latitude": 37.7, "longitude": -122.4}}`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := serve(t, handler, "POST", tc.path, tc.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", w.Code, w.Body)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Errorf("error body %q", w.Body)
+			}
+		})
+	}
+}
+
+func TestWriteServiceErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("completing trip: %w", ErrVersionConflict), http.StatusConflict},
+		{ErrInvalidTransition{From: Requested, To: Completed}, http.StatusConflict},
+		{ErrNotTripDriver, http.StatusForbidden},
+		{ErrTripNotFound, http.StatusNotFound},
+		{ErrInvalidStars, http.StatusBadRequest},
+		{ErrOutsideServiceArea, http.StatusUnprocessableEntity},
+		{ErrNoDriverAvailable, http.StatusServiceUnavailable},
+		{errors.New("disk on fire"), http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		writeServiceError(w, tc.err)
+		if w.Code != tc.status {
+			t.Errorf("%v: status %d, want %d", tc.err, w.Code, tc.status)
+		}
+	}
+}
+
+func TestServerPoolQuoteDefaultsDetourRatio(t *testing.T) {
+	handler, _ := newTestServer(t)
+	riders := `"riders": [
+	  {"rider_id": "r1", "pickup": {"latitude": 37.7749, "longitude": -122.4194}, "dropoff": {"latitude": 37.8044, "longitude": -122.2712}},
+	  {"rider_id": "r2", "pickup": {"latitude": 37.7800, "longitude": -122.4100}, "dropoff": {"latitude": 37.8044, "longitude": -122.2712}}]`
+	quote := func(body string) []poolFareJSON {
+		t.Helper()
+		w := serve(t, handler, "POST", "/pools/quote", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+		}
+		var resp struct{ Fares []poolFareJSON }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Fares
+	}
+
+	for _, fare := range quote("{" + riders + "}") {
+		if fare.SharedMiles >= fare.RiddenMiles {
+			t.Errorf("default detour ratio: rider %s shared none of %.2f miles", fare.RiderID, fare.RiddenMiles)
+		}
+	}
+	// With no detour allowed, the riders can only be served one after the other.
+	for _, fare := range quote(`{"max_detour_ratio": 0, ` + riders + "}") {
+		if fare.SharedMiles != fare.RiddenMiles {
+			t.Errorf("no detour: rider %s shared %.2f of %.2f miles", fare.RiderID, fare.RiddenMiles-fare.SharedMiles, fare.RiddenMiles)
+		}
+	}
+	if w := serve(t, handler, "POST", "/pools/quote", `{"max_detour_ratio": -1, `+riders+"}"); w.Code != http.StatusBadRequest {
+		t.Errorf("negative detour ratio: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// SimConfig describes a synthetic marketplace. The same Seed always yields
+// the same riders and drivers, so strategies can be compared on identical
+// demand.
+type SimConfig struct {
+	Seed        int64
+	Start       time.Time
+	Duration    time.Duration
+	Step        time.Duration
+	Center      Location
+	RadiusMiles float64
+	Drivers     int
+	ShiftLength time.Duration
+	// DemandCurve is ride requests per hour for each hour of the day, in
+	// the timezone of Start.
+	DemandCurve [24]float64
+	SpeedMPH    float64
+	// Patience is how long a rider waits for a match before cancelling.
+	Patience time.Duration
+	// Elasticity is how strongly riders turn down fares above the standard
+	// fare: a rider accepts with probability (standard/quoted)^Elasticity.
+	Elasticity float64
+	// Currency is what revenue is reported in. Fares in any other currency
+	// are left out of it and logged.
+	Currency Currency
+}
+
+func DefaultSimConfig() SimConfig {
+	return SimConfig{
+		Seed:        1,
+		Start:       time.Date(2026, time.January, 5, 0, 0, 0, 0, time.Local),
+		Duration:    24 * time.Hour,
+		Step:        30 * time.Second,
+		Center:      Location{Latitude: 37.7749, Longitude: -122.4194},
+		RadiusMiles: 4,
+		Drivers:     40,
+		ShiftLength: 8 * time.Hour,
+		DemandCurve: [24]float64{8, 5, 3, 2, 2, 4, 10, 25, 35, 25, 18, 20, 24, 20, 18, 20, 28, 38, 34, 26, 22, 20, 18, 12},
+		SpeedMPH:    20,
+		Patience:    5 * time.Minute,
+		Elasticity:  1.5,
+		Currency:    USD,
+	}
+}
+
+// SimReport holds the marketplace metrics of one strategy's run.
+type SimReport struct {
+	Strategy    string
+	Requests    int // riders who asked for a quote
+	Declined    int // riders who turned the quote down
+	Completed   int
+	Cancelled   int // riders who gave up waiting for a match
+	WaitMean    time.Duration
+	WaitP90     time.Duration
+	Utilization float64 // share of online driver time spent en route or on a trip
+	Revenue     Money   // in the run's SimConfig.Currency, even with no trips
+}
+
+// CancellationRate is the share of booked trips that were cancelled.
+func (r SimReport) CancellationRate() float64 {
+	booked := r.Requests - r.Declined
+	if booked == 0 {
+		return 0
+	}
+	return float64(r.Cancelled) / float64(booked)
+}
+
+// RevenuePerTrip is the mean fare of completed trips, in Revenue's currency.
+func (r SimReport) RevenuePerTrip() Money {
+	if r.Completed == 0 {
+		return NewMoney(0, r.Revenue.Currency)
+	}
+	return r.Revenue.Mul(1 / float64(r.Completed))
+}
+
+// PricingFactory builds the strategy under test for one run. market is the
+// run's dispatcher, for strategies that price from supply and demand, and
+// clock its virtual clock.
+type PricingFactory func(market MarketStats, clock Clock) PriceCalculator
+
+type simRider struct {
+	id          string
+	at          time.Time
+	pickup      *Location
+	dropoff     *Location
+	willingness float64 // accepts a quote if below the acceptance probability
+}
+
+type simDriver struct {
+	driver     *Driver
+	shiftStart time.Time
+	shiftEnd   time.Time
+	home       *Location
+	online     bool
+	busySince  time.Time
+}
+
+type simEvent struct {
+	at       time.Time
+	tripID   string
+	driverID string
+	complete bool // false: arrive at pickup and start; true: drop off
+}
+
+// Simulation runs synthetic riders and drivers through the real dispatcher
+// and trip service on a virtual clock.
+type Simulation struct {
+	config   SimConfig
+	clock    *VirtualClock
+	pricing  PriceCalculator
+	standard PriceCalculator
+	eta      ConstantSpeedETA
+
+	dispatcher *Dispatcher
+	trips      *TripService
+	riders     []simRider
+	drivers    map[string]*simDriver
+	events     []simEvent
+	pending    []string // trip IDs waiting for a driver, oldest first
+
+	report     SimReport
+	waits      []time.Duration
+	busyTime   time.Duration
+	onlineTime time.Duration
+}
+
+func NewSimulation(config SimConfig, strategy string, newPricing PricingFactory) *Simulation {
+	clock := NewVirtualClock(config.Start)
+	policy := DriverPolicy{HeartbeatTTL: 2 * config.Step, MaxShift: config.ShiftLength}
+	dispatcher := NewDispatcher(clock, policy)
+
+	sim := &Simulation{
+		config:     config,
+		clock:      clock,
+		pricing:    newPricing(dispatcher, clock),
+		standard:   StandardPricingCalculator{},
+		eta:        ConstantSpeedETA{SpeedMPH: config.SpeedMPH, Distance: HaversineDistance{}},
+		dispatcher: dispatcher,
+		trips:      NewTripService(NewTripRepositoryMemory(), clock),
+		drivers:    make(map[string]*simDriver),
+		report:     SimReport{Strategy: strategy, Revenue: NewMoney(0, config.Currency)},
+	}
+	dispatcher.ReadRequestsFrom(sim.trips.GetTrip)
+	sim.generate(rand.New(rand.NewSource(config.Seed)))
+	return sim
+}
+
+// generate draws the drivers' shifts and the riders' requests up front, so
+// they don't depend on anything the strategy does.
+func (s *Simulation) generate(rng *rand.Rand) {
+	end := s.config.Start.Add(s.config.Duration)
+	for i := 0; i < s.config.Drivers; i++ {
+		id := fmt.Sprintf("sim-driver-%d", i)
+		shiftStart := s.config.Start.Add(time.Duration(rng.Int63n(int64(s.config.Duration))))
+		s.drivers[id] = &simDriver{
+			driver: &Driver{
+				ID:      id,
+				Name:    id,
+				Vehicle: Vehicle{Class: Economy},
+			},
+			shiftStart: shiftStart,
+			shiftEnd:   shiftStart.Add(s.config.ShiftLength),
+			home:       s.randomLocation(rng),
+		}
+	}
+
+	// Requests arrive as a Poisson process whose rate follows the demand curve.
+	for at := s.config.Start; at.Before(end); {
+		rate := s.config.DemandCurve[at.Hour()]
+		if rate <= 0 {
+			at = at.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		at = at.Add(time.Duration(rng.ExpFloat64() / rate * float64(time.Hour)))
+		if !at.Before(end) {
+			break
+		}
+		s.riders = append(s.riders, simRider{
+			id:          fmt.Sprintf("sim-trip-%d", len(s.riders)),
+			at:          at,
+			pickup:      s.randomLocation(rng),
+			dropoff:     s.randomLocation(rng),
+			willingness: rng.Float64(),
+		})
+	}
+}
+
+// randomLocation is uniform over the disc around the center.
+func (s *Simulation) randomLocation(rng *rand.Rand) *Location {
+	r := s.config.RadiusMiles * math.Sqrt(rng.Float64())
+	theta := 2 * math.Pi * rng.Float64()
+	return &Location{
+		Latitude:  s.config.Center.Latitude + r*math.Cos(theta)/69.0,
+		Longitude: s.config.Center.Longitude + r*math.Sin(theta)/(69.0*math.Cos(toRadians(s.config.Center.Latitude))),
+	}
+}
+
+func (s *Simulation) Run() SimReport {
+	end := s.config.Start.Add(s.config.Duration)
+	next := 0
+	for now := s.clock.Now(); now.Before(end); now = s.clock.Now() {
+		s.runEvents(now)
+		s.updateShifts(now)
+		for ; next < len(s.riders) && s.riders[next].at.Before(now.Add(s.config.Step)); next++ {
+			s.request(s.riders[next])
+		}
+		s.match(now)
+		s.clock.Advance(s.config.Step)
+	}
+	return s.finish()
+}
+
+func (s *Simulation) updateShifts(now time.Time) {
+	for _, d := range s.drivers {
+		switch {
+		case !d.online && !now.Before(d.shiftStart) && now.Before(d.shiftEnd):
+			s.dispatcher.GoOnline(d.driver, d.home)
+			d.online = true
+		case d.online && !now.Before(d.shiftEnd) && d.busySince.IsZero():
+			s.dispatcher.GoOffline(d.driver.ID)
+			d.online = false
+		}
+		if d.online {
+			s.onlineTime += s.config.Step
+			if d.busySince.IsZero() {
+				s.dispatcher.Heartbeat(d.driver.ID, d.home)
+			}
+		}
+	}
+}
+
+// request shows the rider a quote and books the trip if they accept it.
+func (s *Simulation) request(rider simRider) {
+	s.report.Requests++
+	trip := NewTrip(rider.id, &User{ID: rider.id}, rider.pickup, rider.dropoff, rider.at)
+
+	var quote Money
+	if quoter, ok := s.pricing.(Quoter); ok {
+		quote = quoter.Quote(trip).Total()
+	} else {
+		quote = s.pricing.CalculateFare(trip).Total()
+	}
+	reference := s.standard.CalculateFare(trip).Total()
+	if quote.IsPositive() && rider.willingness >= math.Pow(reference.Float64()/quote.Float64(), s.config.Elasticity) {
+		s.report.Declined++
+		return
+	}
+
+	if _, err := s.trips.SubmitTrip(trip); err != nil {
+		return
+	}
+	s.dispatcher.TrackRequest(trip.ID)
+	s.pending = append(s.pending, trip.ID)
+}
+
+func (s *Simulation) match(now time.Time) {
+	waiting := s.pending[:0]
+	for _, id := range s.pending {
+		trip, err := s.trips.AssignNearestDriver(id, s.dispatcher, defaultMatchCandidates, defaultMatchRadiusMiles)
+		if err == nil {
+			driver := s.drivers[trip.Driver.ID]
+			driver.busySince = now
+			pickupAt := now.Add(s.drive(driver.driver.Location, trip.PickupLocation))
+			s.waits = append(s.waits, pickupAt.Sub(trip.RequestedAt))
+			s.schedule(simEvent{at: pickupAt, tripID: id, driverID: driver.driver.ID})
+			continue
+		}
+
+		trip, _ = s.trips.GetTrip(id)
+		if trip != nil && now.Sub(trip.RequestedAt) >= s.config.Patience {
+			s.trips.CancelTrip(id, CancelledByRider, "no driver found", nil)
+			s.dispatcher.DropRequest(id)
+			s.report.Cancelled++
+			continue
+		}
+		waiting = append(waiting, id)
+	}
+	s.pending = waiting
+}
+
+func (s *Simulation) drive(from, to *Location) time.Duration {
+	return time.Duration(s.eta.DurationMinutes(from, to) * float64(time.Minute))
+}
+
+func (s *Simulation) schedule(event simEvent) {
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].at.After(event.at) })
+	s.events = append(s.events, simEvent{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = event
+}
+
+func (s *Simulation) runEvents(now time.Time) {
+	for len(s.events) > 0 && !s.events[0].at.After(now) {
+		event := s.events[0]
+		s.events = s.events[1:]
+		driver := s.drivers[event.driverID]
+
+		if !event.complete {
+			trip, err := s.trips.StartTrip(event.tripID)
+			if err != nil {
+				continue
+			}
+			s.dispatcher.StartDriverTrip(event.driverID)
+			s.schedule(simEvent{
+				at:       event.at.Add(s.drive(trip.PickupLocation, trip.DropoffLocation)),
+				tripID:   event.tripID,
+				driverID: event.driverID,
+				complete: true,
+			})
+			continue
+		}
+
+		trip, err := s.trips.CompleteTrip(event.tripID, s.pricing)
+		if err != nil {
+			continue
+		}
+		s.report.Completed++
+		if revenue, err := s.report.Revenue.Add(trip.Fare); err == nil {
+			s.report.Revenue = revenue
+		} else {
+			log.Printf("simulate: trip %s revenue: %v", trip.ID, err)
+		}
+		s.busyTime += event.at.Sub(driver.busySince)
+		driver.busySince = time.Time{}
+		driver.home = trip.DropoffLocation
+		s.dispatcher.Heartbeat(event.driverID, trip.DropoffLocation)
+		s.dispatcher.ReleaseDriver(event.driverID)
+	}
+}
+
+func (s *Simulation) finish() SimReport {
+	report := s.report
+	if len(s.waits) > 0 {
+		sorted := append([]time.Duration(nil), s.waits...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		var total time.Duration
+		for _, wait := range sorted {
+			total += wait
+		}
+		report.WaitMean = (total / time.Duration(len(sorted))).Round(time.Second)
+		report.WaitP90 = sorted[len(sorted)*9/10].Round(time.Second)
+	}
+	if s.onlineTime > 0 {
+		report.Utilization = float64(s.busyTime) / float64(s.onlineTime)
+	}
+	return report
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func smallSimConfig() SimConfig {
+	config := DefaultSimConfig()
+	config.Start = time.Date(2026, time.January, 5, 7, 0, 0, 0, time.UTC)
+	config.Duration = 2 * time.Hour
+	config.Drivers = 8
+	return config
+}
+
+func TestSimulationIsReproducible(t *testing.T) {
+	standard := func(MarketStats, Clock) PriceCalculator { return StandardPricingCalculator{} }
+	first := NewSimulation(smallSimConfig(), "standard", standard).Run()
+	second := NewSimulation(smallSimConfig(), "standard", standard).Run()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed, different reports:\n%+v\n%+v", first, second)
+	}
+	if first.Completed == 0 || first.Requests == 0 {
+		t.Fatalf("report %+v has no trips", first)
+	}
+	if first.Revenue.Currency != USD {
+		t.Errorf("revenue in %q, want %s", first.Revenue.Currency, USD)
+	}
+
+	config := smallSimConfig()
+	config.Seed++
+	if other := NewSimulation(config, "standard", standard).Run(); reflect.DeepEqual(first, other) {
+		t.Error("another seed gave the same report")
+	}
+}
+
+func TestSimReportRates(t *testing.T) {
+	report := SimReport{Requests: 10, Declined: 2, Completed: 3, Cancelled: 2, Revenue: MustParseMoney("10.00", USD)}
+	if got := report.CancellationRate(); got != 0.25 {
+		t.Errorf("cancellation rate = %v, want 2 of 8 booked", got)
+	}
+	if got, want := report.RevenuePerTrip(), MustParseMoney("3.33", USD); got != want {
+		t.Errorf("revenue per trip = %s, want %s", got, want)
+	}
+
+	// A run without drivers still reports in its currency.
+	config := smallSimConfig()
+	config.Drivers = 0
+	config.Currency = EUR
+	empty := NewSimulation(config, "standard", func(MarketStats, Clock) PriceCalculator { return StandardPricingCalculator{} }).Run()
+	if empty.Completed != 0 || empty.Revenue != NewMoney(0, EUR) || empty.RevenuePerTrip() != NewMoney(0, EUR) {
+		t.Errorf("empty run: %d completed, revenue %v, per trip %v; want zero EUR", empty.Completed, empty.Revenue, empty.RevenuePerTrip())
+	}
+	if got := (SimReport{}).CancellationRate(); got != 0 {
+		t.Errorf("cancellation rate with nothing booked = %v, want 0", got)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// zoneSize is the edge of a surge pricing zone in degrees (~3.5 miles of latitude).
+const zoneSize = 0.05
+
+func zoneFor(loc *Location) string {
+	return fmt.Sprintf("%d:%d",
+		int(math.Floor(loc.Latitude/zoneSize)),
+		int(math.Floor(loc.Longitude/zoneSize)))
+}
+
+// MarketStats reports live supply and demand for a pricing zone, given as
+// a test of whether a location is in it.
+type MarketStats interface {
+	ZoneStats(inZone func(loc *Location) bool) (openTrips, availableDrivers int)
+}
+
+// SurgePricingCalculator multiplies the base fare by a per-zone surge factor.
+//
+// The raw factor is the demand/supply ratio of the pickup zone, clamped to
+// [1, MaxMultiplier]. It is then smoothed with an exponential moving average
+// over time and rounded to 0.1 so that a single request or driver going
+// offline does not make the price flap. The average decays with HalfLife,
+// not per sample, so a busy zone that is quoted often moves no faster than
+// a quiet one.
+//
+// With Zones set, a pickup inside a named geofence zone surges with that
+// zone; pickups outside every named zone fall back to the grid.
+type SurgePricingCalculator struct {
+	Base          PriceCalculator
+	Market        MarketStats
+	Clock         Clock
+	Zones         *Geofence
+	MaxMultiplier float64
+	// HalfLife is how long the multiplier takes to move halfway to a new
+	// raw factor. Zero follows the raw factor immediately.
+	HalfLife time.Duration
+
+	mu       sync.Mutex
+	smoothed map[string]surgeSample
+}
+
+type surgeSample struct {
+	multiplier float64
+	at         time.Time
+}
+
+func NewSurgePricingCalculator(base PriceCalculator, market MarketStats, clock Clock, maxMultiplier float64, halfLife time.Duration) *SurgePricingCalculator {
+	if maxMultiplier < 1 {
+		maxMultiplier = 1
+	}
+	return &SurgePricingCalculator{
+		Base:          base,
+		Market:        market,
+		Clock:         clock,
+		MaxMultiplier: maxMultiplier,
+		HalfLife:      halfLife,
+		smoothed:      make(map[string]surgeSample),
+	}
+}
+
+// Multiplier samples the market for the zone and returns the smoothed surge factor.
+func (c *SurgePricingCalculator) Multiplier(loc *Location) float64 {
+	zone := c.zoneOf(loc)
+	openTrips, availableDrivers := c.Market.ZoneStats(func(other *Location) bool {
+		return c.zoneOf(other) == zone
+	})
+
+	raw := 1.0
+	switch {
+	case availableDrivers == 0 && openTrips > 0:
+		raw = c.MaxMultiplier
+	case availableDrivers > 0:
+		raw = float64(openTrips) / float64(availableDrivers)
+	}
+	raw = math.Min(math.Max(raw, 1), c.MaxMultiplier)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Clock.Now()
+	prev, ok := c.smoothed[zone]
+	if !ok {
+		prev = surgeSample{multiplier: 1, at: now}
+	}
+	weight := 1.0
+	if c.HalfLife > 0 {
+		weight = 1 - math.Exp2(-float64(now.Sub(prev.at))/float64(c.HalfLife))
+	}
+	next := prev.multiplier + weight*(raw-prev.multiplier)
+	c.smoothed[zone] = surgeSample{multiplier: next, at: now}
+
+	return math.Round(next*10) / 10
+}
+
+// zoneOf is the first named zone containing loc, or its grid zone.
+func (c *SurgePricingCalculator) zoneOf(loc *Location) string {
+	if c.Zones != nil {
+		if zones := c.Zones.ZonesAt(loc); len(zones) > 0 {
+			return zones[0].Name
+		}
+	}
+	return zoneFor(loc)
+}
+
+// Quote prices the trip at the current surge and locks that multiplier into
+// the trip, so CompleteTrip later charges what the rider was shown.
+func (c *SurgePricingCalculator) Quote(trip *Trip) FareBreakdown {
+	trip.SurgeMultiplier = c.Multiplier(trip.PickupLocation)
+	return c.surged(trip, trip.SurgeMultiplier)
+}
+
+func (c *SurgePricingCalculator) CalculateFare(trip *Trip) FareBreakdown {
+	multiplier := trip.SurgeMultiplier
+	if multiplier == 0 {
+		multiplier = c.Multiplier(trip.PickupLocation)
+	}
+	return c.surged(trip, multiplier)
+}
+
+func (c *SurgePricingCalculator) surged(trip *Trip, multiplier float64) FareBreakdown {
+	fare := c.Base.CalculateFare(trip)
+	if multiplier > 1 {
+		fare.Scale(FareSurcharge, fmt.Sprintf("Surge ×%.1f", multiplier), multiplier)
+	}
+	return fare
+}
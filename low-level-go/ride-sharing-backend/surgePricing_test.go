@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type fixedMarket struct{ openTrips, availableDrivers int }
+
+func (m fixedMarket) ZoneStats(func(*Location) bool) (int, int) {
+	return m.openTrips, m.availableDrivers
+}
+
+func TestSurgeSmoothingFollowsTimeNotQuotes(t *testing.T) {
+	clock := NewVirtualClock(time.Date(2026, 10, 13, 18, 0, 0, 0, time.UTC))
+	quiet := NewSurgePricingCalculator(StandardPricingCalculator{}, fixedMarket{4, 2}, clock, 3, 2*time.Minute)
+	busy := NewSurgePricingCalculator(StandardPricingCalculator{}, fixedMarket{4, 2}, clock, 3, 2*time.Minute)
+
+	quiet.Multiplier(testPickup)
+	for i := 0; i < 100; i++ {
+		busy.Multiplier(testPickup)
+	}
+	clock.Advance(2 * time.Minute)
+
+	// One half-life moves the multiplier halfway from 1 to the raw 2.
+	if got := quiet.Multiplier(testPickup); got != 1.5 {
+		t.Errorf("quiet zone = %v, want 1.5", got)
+	}
+	if got := busy.Multiplier(testPickup); got != 1.5 {
+		t.Errorf("busy zone = %v, want 1.5 however often it was quoted", got)
+	}
+}
+
+func TestZoneStatsReadsRequestState(t *testing.T) {
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	dispatcher.ReadRequestsFrom(trips.GetTrip)
+
+	for _, id := range []string{"t1", "t2"} {
+		if _, err := trips.RequestTrip(id, &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+			t.Fatal(err)
+		}
+		dispatcher.TrackRequest(id)
+	}
+	zone := func(loc *Location) bool { return zoneFor(loc) == zoneFor(testPickup) }
+	if open, _ := dispatcher.ZoneStats(zone); open != 2 {
+		t.Fatalf("open trips = %d, want 2", open)
+	}
+
+	if _, err := trips.CancelTrip("t1", CancelledBySystem, "no driver found", nil); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := dispatcher.ZoneStats(zone); open != 1 {
+		t.Errorf("open trips after a cancel = %d, want 1", open)
+	}
+}
+
+func TestSurgeKeyedByNamedZone(t *testing.T) {
+	downtown := Zone{Name: "Downtown", Kind: CongestionZone, Polygons: []Polygon{{Ring{
+		{Latitude: 37.70, Longitude: -122.50}, {Latitude: 37.70, Longitude: -122.30},
+		{Latitude: 37.85, Longitude: -122.30}, {Latitude: 37.85, Longitude: -122.50},
+	}}}}
+	west := &Location{Latitude: 37.72, Longitude: -122.48}
+	east := &Location{Latitude: 37.83, Longitude: -122.32}
+	outside := &Location{Latitude: 37.90, Longitude: -122.48}
+	if zoneFor(west) == zoneFor(east) {
+		t.Fatal("test points must lie in different grid zones")
+	}
+
+	var inZone func(*Location) bool
+	market := marketFunc(func(f func(*Location) bool) (int, int) {
+		inZone = f
+		return 0, 1
+	})
+	surge := NewSurgePricingCalculator(StandardPricingCalculator{}, market, SystemClock{}, 3, 0)
+	surge.Zones = &Geofence{Zones: []Zone{downtown}}
+
+	surge.Multiplier(west)
+	if !inZone(east) || inZone(outside) {
+		t.Error("a downtown pickup should count the whole named zone and nothing outside it")
+	}
+	surge.Multiplier(outside)
+	if inZone(west) || !inZone(outside) {
+		t.Error("a pickup outside named zones should fall back to its grid zone")
+	}
+}
+
+type marketFunc func(inZone func(*Location) bool) (int, int)
+
+func (f marketFunc) ZoneStats(inZone func(*Location) bool) (int, int) { return f(inZone) }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotTripRider         = errors.New("not the rider of this trip")
+	ErrTrackingTokenExpired = errors.New("tracking token has expired")
+	ErrTripNotTrackable     = errors.New("trip has ended")
+)
+
+const (
+	trackingBufferSize   = 16
+	trackingTokenKeySize = 32
+	// trackingTokenTTL is how long after the pickup time a token works.
+	trackingTokenTTL = 24 * time.Hour
+)
+
+// TrackingAuth issues the token that lets a trip's rider follow it. The
+// token is an HMAC of the trip and rider IDs, so it can't be forged for
+// another trip and needs no storage. Tokens stay valid across restarts and
+// instances as long as they share the key, until trackingTokenTTL after
+// the trip's pickup time.
+type TrackingAuth struct {
+	key []byte
+}
+
+// NewTrackingAuth signs tokens with key, given in hex. The key must be at
+// least 32 bytes.
+func NewTrackingAuth(hexKey string) (TrackingAuth, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return TrackingAuth{}, fmt.Errorf("tracking key: %w", err)
+	}
+	if len(key) < trackingTokenKeySize {
+		return TrackingAuth{}, fmt.Errorf("tracking key: %d bytes, want at least %d", len(key), trackingTokenKeySize)
+	}
+	return TrackingAuth{key: key}, nil
+}
+
+// RandomTrackingAuth signs tokens with a key of its own, so they stop
+// working when the process exits.
+func RandomTrackingAuth() TrackingAuth {
+	key := make([]byte, trackingTokenKeySize)
+	rand.Read(key)
+	return TrackingAuth{key: key}
+}
+
+func (a TrackingAuth) Token(trip *Trip) string {
+	return hex.EncodeToString(a.mac(trip))
+}
+
+// Verify checks that token was issued to the trip's rider and is still
+// valid at now.
+func (a TrackingAuth) Verify(trip *Trip, token string, now time.Time) error {
+	given, err := hex.DecodeString(token)
+	if err != nil || trip.Rider == nil || !hmac.Equal(given, a.mac(trip)) {
+		return ErrNotTripRider
+	}
+	if now.After(trip.pickupTime().Add(trackingTokenTTL)) {
+		return ErrTrackingTokenExpired
+	}
+	return nil
+}
+
+func (a TrackingAuth) mac(trip *Trip) []byte {
+	riderID := ""
+	if trip.Rider != nil {
+		riderID = trip.Rider.ID
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(trip.ID + "\x00" + riderID))
+	return mac.Sum(nil)
+}
+
+// TrackingUpdate is one message on a trip's live stream. Target is
+// "pickup" while the driver is on the way, then "stop" or "dropoff" during
+// the ride; ETA and distance are to that target.
+type TrackingUpdate struct {
+	TripID         string
+	State          TripState
+	DriverLocation *Location
+	Target         string
+	ETAMinutes     float64
+	DistanceMiles  float64
+	At             time.Time
+}
+
+// TrackingSubscription delivers a trip's updates until the trip ends or
+// Close is called. A slow reader loses the oldest updates, never the last.
+type TrackingSubscription struct {
+	tripID  string
+	updates chan TrackingUpdate
+	tracker *TripTracker
+	closed  bool // guarded by tracker.mu
+}
+
+func (s *TrackingSubscription) Updates() <-chan TrackingUpdate {
+	return s.updates
+}
+
+func (s *TrackingSubscription) Close() {
+	s.tracker.unsubscribe(s)
+}
+
+// TripTracker fans driver positions and ETA recalculations out to the
+// riders following their trips. It learns about trips from
+// TripService.OnTripSaved and about positions from DriverMoved.
+type TripTracker struct {
+	router     *Router
+	dispatcher *Dispatcher
+	clock      Clock
+
+	mu       sync.Mutex
+	subs     map[string]map[*TrackingSubscription]bool
+	trips    map[string]*Trip  // trips with subscribers, latest saved version
+	byDriver map[string]string // driver ID -> tracked trip ID
+	position map[string]*Location
+}
+
+func NewTripTracker(router *Router, dispatcher *Dispatcher, clock Clock) *TripTracker {
+	return &TripTracker{
+		router:     router,
+		dispatcher: dispatcher,
+		clock:      clock,
+		subs:       make(map[string]map[*TrackingSubscription]bool),
+		trips:      make(map[string]*Trip),
+		byDriver:   make(map[string]string),
+		position:   make(map[string]*Location),
+	}
+}
+
+// Subscribe starts following trip. The first update, with the trip's
+// current state, is sent right away.
+func (t *TripTracker) Subscribe(trip *Trip) (*TrackingSubscription, error) {
+	if trip.State.IsTerminal() {
+		return nil, ErrTripNotTrackable
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sub := &TrackingSubscription{
+		tripID:  trip.ID,
+		updates: make(chan TrackingUpdate, trackingBufferSize),
+		tracker: t,
+	}
+	if t.subs[trip.ID] == nil {
+		t.subs[trip.ID] = make(map[*TrackingSubscription]bool)
+	}
+	t.subs[trip.ID][sub] = true
+	if known, ok := t.trips[trip.ID]; !ok || known.Version < trip.Version {
+		t.track(trip)
+	}
+	deliver(sub, t.update(t.trips[trip.ID]))
+	return sub, nil
+}
+
+func (t *TripTracker) unsubscribe(sub *TrackingSubscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drop(sub)
+}
+
+// drop closes one subscription and forgets the trip when nobody follows it
+// any more. Callers hold mu.
+func (t *TripTracker) drop(sub *TrackingSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.updates)
+	delete(t.subs[sub.tripID], sub)
+	if len(t.subs[sub.tripID]) > 0 {
+		return
+	}
+	delete(t.subs, sub.tripID)
+	if trip := t.trips[sub.tripID]; trip != nil && trip.Driver != nil && t.byDriver[trip.Driver.ID] == sub.tripID {
+		delete(t.byDriver, trip.Driver.ID)
+		delete(t.position, trip.Driver.ID)
+	}
+	delete(t.trips, sub.tripID)
+}
+
+// track records the latest version of a followed trip. Callers hold mu.
+func (t *TripTracker) track(trip *Trip) {
+	t.trips[trip.ID] = trip
+	if trip.Driver != nil {
+		t.byDriver[trip.Driver.ID] = trip.ID
+	}
+}
+
+// TripSaved is registered with TripService.OnTripSaved. Followers get the
+// new state, and once it is terminal their streams are closed.
+func (t *TripTracker) TripSaved(trip *Trip) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.subs[trip.ID]) == 0 {
+		return
+	}
+	// Observers run after the save, so a slower one may report an older version.
+	if known := t.trips[trip.ID]; known != nil && known.Version > trip.Version {
+		return
+	}
+	t.track(trip)
+	update := t.update(trip)
+	for sub := range t.subs[trip.ID] {
+		deliver(sub, update)
+		if trip.State.IsTerminal() {
+			t.drop(sub)
+		}
+	}
+}
+
+// DriverMoved pushes a new position, with a fresh ETA, to the followers of
+// the driver's trip.
+func (t *TripTracker) DriverMoved(driverID string, loc *Location) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tripID, ok := t.byDriver[driverID]
+	if !ok {
+		return
+	}
+	t.position[driverID] = loc
+	update := t.update(t.trips[tripID])
+	for sub := range t.subs[tripID] {
+		deliver(sub, update)
+	}
+}
+
+// update builds the message for the trip's current state. Callers hold mu.
+func (t *TripTracker) update(trip *Trip) TrackingUpdate {
+	update := TrackingUpdate{TripID: trip.ID, State: trip.State, At: t.clock.Now()}
+	if trip.Driver == nil {
+		return update
+	}
+	if t.position[trip.Driver.ID] == nil {
+		// No heartbeat since the trip was followed: start from the last
+		// position the dispatcher has.
+		t.position[trip.Driver.ID], _ = t.dispatcher.DriverLocation(trip.Driver.ID)
+	}
+	update.DriverLocation = t.position[trip.Driver.ID]
+
+	var target *Location
+	switch trip.State {
+	case DriverAssigned:
+		update.Target, target = "pickup", trip.PickupLocation
+	case InProgress:
+		update.Target, target = trip.NextDestination()
+	}
+	if target != nil && update.DriverLocation != nil {
+		route := t.router.Route(update.DriverLocation, target)
+		update.ETAMinutes = route.DurationMinutes
+		update.DistanceMiles = route.DistanceMiles
+	}
+	return update
+}
+
+// deliver sends without blocking, dropping the oldest queued update when
+// the subscriber has fallen behind.
+func deliver(sub *TrackingSubscription, update TrackingUpdate) {
+	for {
+		select {
+		case sub.updates <- update:
+			return
+		default:
+		}
+		select {
+		case <-sub.updates:
+		default:
+		}
+	}
+}
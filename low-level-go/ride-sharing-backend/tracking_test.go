@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackingTokensOutliveTheProcess(t *testing.T) {
+	key := strings.Repeat("ab", trackingTokenKeySize)
+	before, err := NewTrackingAuth(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := NewTrackingAuth(key)
+	trip := NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, testNow)
+	if err := after.Verify(trip, before.Token(trip), testNow); err != nil {
+		t.Errorf("token from the same key rejected: %v", err)
+	}
+	if err := RandomTrackingAuth().Verify(trip, before.Token(trip), testNow); err == nil {
+		t.Error("token accepted under another key")
+	}
+
+	for _, bad := range []string{"", "abcd", "not hex"} {
+		if _, err := NewTrackingAuth(bad); err == nil {
+			t.Errorf("NewTrackingAuth(%q) accepted", bad)
+		}
+	}
+}
+
+func TestTrackingTokenExpires(t *testing.T) {
+	auth := RandomTrackingAuth()
+	trip := NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, testNow)
+	token := auth.Token(trip)
+	if err := auth.Verify(trip, token, testNow.Add(trackingTokenTTL)); err != nil {
+		t.Errorf("token at the end of its life: %v", err)
+	}
+	if err := auth.Verify(trip, token, testNow.Add(trackingTokenTTL+time.Second)); !errors.Is(err, ErrTrackingTokenExpired) {
+		t.Errorf("token past its life: got %v, want ErrTrackingTokenExpired", err)
+	}
+
+	// A scheduled ride's token lives from its pickup time.
+	scheduled := NewScheduledTrip("t2", &User{ID: "r1"}, testPickup, testDropoff, testNow, testNow.Add(72*time.Hour))
+	if err := auth.Verify(scheduled, auth.Token(scheduled), testNow.Add(72*time.Hour)); err != nil {
+		t.Errorf("scheduled ride's token at pickup: %v", err)
+	}
+}
+
+// openTrack starts following t1 and returns the stream once the server has
+// subscribed it.
+func openTrack(t *testing.T, server *httptest.Server, token string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/trips/t1/track?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("track: status %d: %s", resp.StatusCode, body)
+	}
+	return resp
+}
+
+func TestTrackingStreamClosesWhenTripEnds(t *testing.T) {
+	for _, end := range []string{"complete", "cancel"} {
+		t.Run(end, func(t *testing.T) {
+			handler, _ := newTestServer(t)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			driverOnline(t, handler, "d1")
+			token := serveTrip(t, handler, "POST", "/trips", testTripRequest, http.StatusCreated).TrackingToken
+			serveTrip(t, handler, "POST", "/trips/t1/assign", "", http.StatusOK)
+			if end == "complete" {
+				serveTrip(t, handler, "POST", "/trips/t1/start", "", http.StatusOK)
+			}
+
+			stream := openTrack(t, server, token)
+			serveTrip(t, handler, "POST", "/trips/t1/"+end, "", http.StatusOK)
+			// The stream ends by itself, so reading it to the end returns.
+			body, err := io.ReadAll(stream.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := string(body)
+			if !strings.HasSuffix(events, "event: closed\ndata: {}\n\n") {
+				t.Errorf("stream did not end with closed:\n%s", events)
+			}
+			if want := map[string]TripState{"complete": Completed, "cancel": Cancelled}[end]; !strings.Contains(events, `"state":"`+string(want)+`"`) {
+				t.Errorf("stream has no %s update:\n%s", want, events)
+			}
+			if w := serve(t, handler, "GET", "/trips/t1/track?token="+token, ""); w.Code != http.StatusConflict {
+				t.Errorf("following an ended trip: status %d, want 409", w.Code)
+			}
+		})
+	}
+}
+
+func TestTrackingRejectsForeignAndExpiredTokens(t *testing.T) {
+	handler, clock := newTestServer(t)
+	token := serveTrip(t, handler, "POST", "/trips", testTripRequest, http.StatusCreated).TrackingToken
+	other := serveTrip(t, handler, "POST", "/trips", strings.Replace(testTripRequest, `"t1"`, `"t2"`, 1), http.StatusCreated).TrackingToken
+
+	for name, bad := range map[string]string{"another trip's": other, "garbled": "zz", "missing": ""} {
+		if w := serve(t, handler, "GET", "/trips/t1/track?token="+bad, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s token: status %d, want 401", name, w.Code)
+		}
+	}
+	clock.Advance(trackingTokenTTL + time.Minute)
+	w := serve(t, handler, "GET", "/trips/t1/track?token="+token, "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrTrackingTokenExpired.Error()) {
+		t.Errorf("expired token: status %d %s, want 401", w.Code, w.Body)
+	}
+}
@@ -1,68 +1,263 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTripNotInProgress   = errors.New("cannot record location in current state")
+	ErrNotEnRoute          = errors.New("driver can only arrive at pickup once assigned")
+	ErrNotTripDriver       = errors.New("driver is not assigned to this trip")
+	ErrUnknownVehicleClass = errors.New("unknown vehicle class")
+)
 
 // Core entities
 type User struct {
+	ID   string
 	Name string
 }
 
-type Driver struct {
-	Name string
+type VehicleClass string
+
+const (
+	Economy VehicleClass = "ECONOMY"
+	XL      VehicleClass = "XL"
+	Premium VehicleClass = "PREMIUM"
+)
+
+// Valid reports whether the class is one the fleet offers.
+func (c VehicleClass) Valid() bool {
+	switch c {
+	case Economy, XL, Premium:
+		return true
+	}
+	return false
 }
 
 // Concrete type
 type Trip struct {
-	ID              string
-	Rider           *User
+	ID    string
+	Rider *User
+	// Driver is a snapshot taken when the driver was assigned; live driver
+	// state stays in the Dispatcher.
 	Driver          *Driver
 	PickupLocation  *Location
 	DropoffLocation *Location
-	State           TripState
-	Fare            float64
+	Waypoints       []Waypoint
+	// DestinationChanges lists every move of DropoffLocation, oldest first.
+	DestinationChanges []DestinationChange
+	PickupZones        []string
+	DropoffZones       []string
+	VehicleClass       VehicleClass
+	RequestedAt        time.Time
+	PickupAt           time.Time
+	ArrivedAt          time.Time
+	StartedAt          time.Time
+	CompletedAt        time.Time
+	Route              []LocationPing
+	State              TripState
+	History            []StateTransition
+	Fare               Money
+	FareBreakdown      []FareLine
+	BilledMiles        float64 // distance the fare was priced on
+	DistanceSource     string  // where BilledMiles was measured
+	SurgeMultiplier    float64
+	PromoCode          string
+	Cancellation       *Cancellation
+	Payment            PaymentStatus
+	ChargeID           string
+	Review             *TripReview
+	Version            int
 }
 
-func NewTrip(id string, rider *User, pickup, dropoff *Location) *Trip {
+type StateTransition struct {
+	From TripState
+	To   TripState
+	At   time.Time
+}
+
+// NewTrip is a trip requested at requestedAt, which should come from the
+// same Clock as the service that will run it.
+func NewTrip(id string, rider *User, pickup, dropoff *Location, requestedAt time.Time) *Trip {
 	return &Trip{
 		ID:              id,
 		Rider:           rider,
 		PickupLocation:  pickup,
 		DropoffLocation: dropoff,
+		VehicleClass:    Economy,
+		RequestedAt:     requestedAt,
 		State:           Requested,
-		Fare:            0.0,
 	}
 }
 
-func (t *Trip) AssignDriver(driver *Driver) error {
-	if t.State != Requested {
-		return errors.New("cannot assign driver in current state")
+// NewScheduledTrip books a trip at requestedAt for a later pickup. It stays
+// Scheduled until a Scheduler releases it into matching.
+func NewScheduledTrip(id string, rider *User, pickup, dropoff *Location, requestedAt, pickupAt time.Time) *Trip {
+	trip := NewTrip(id, rider, pickup, dropoff, requestedAt)
+	trip.State = Scheduled
+	trip.PickupAt = pickupAt
+	return trip
+}
+
+// pickupTime is when the ride begins: the booked pickup for a scheduled
+// trip, else the request.
+func (t *Trip) pickupTime() time.Time {
+	if !t.PickupAt.IsZero() {
+		return t.PickupAt
 	}
-	t.Driver = driver
-	t.State = DriverAssigned
-	return nil
+	return t.RequestedAt
 }
 
-func (t *Trip) StartTrip() error {
+// clone returns a copy of the trip that shares no slices with the original.
+func (t *Trip) clone() *Trip {
+	c := *t
+	c.PickupZones = append([]string(nil), t.PickupZones...)
+	c.DropoffZones = append([]string(nil), t.DropoffZones...)
+	c.Waypoints = append([]Waypoint(nil), t.Waypoints...)
+	c.DestinationChanges = append([]DestinationChange(nil), t.DestinationChanges...)
+	c.Route = append([]LocationPing(nil), t.Route...)
+	c.History = append([]StateTransition(nil), t.History...)
+	c.FareBreakdown = append([]FareLine(nil), t.FareBreakdown...)
+	if t.Cancellation != nil {
+		cancellation := *t.Cancellation
+		c.Cancellation = &cancellation
+	}
+	c.Review = t.Review.clone()
+	return &c
+}
+
+// ReleaseForMatching moves a scheduled trip into Requested so it can be matched.
+func (t *Trip) ReleaseForMatching(at time.Time) error {
+	return t.transition(Requested, at, nil)
+}
+
+// OfferToDriver parks the trip while a driver considers an offer for it.
+func (t *Trip) OfferToDriver(at time.Time) error {
+	return t.transition(Offered, at, nil)
+}
+
+// WithdrawOffer returns the trip to Requested after an offer was declined or expired.
+func (t *Trip) WithdrawOffer(at time.Time) error {
+	return t.transition(Requested, at, nil)
+}
+
+// AssignDriver hands the trip to a driver the Dispatcher picked. Whether the
+// driver is free to take it is the Dispatcher's decision, made under its
+// lock; the trip keeps only a snapshot.
+func (t *Trip) AssignDriver(driver *Driver, at time.Time) error {
+	if driver == nil {
+		return ErrDriverUnavailable
+	}
+	return t.transition(DriverAssigned, at, func() {
+		t.Driver = driver.snapshot()
+	})
+}
+
+// ArriveAtPickup records when the driver reached the pickup, which starts
+// the no-show clock. Arriving again keeps the first time.
+func (t *Trip) ArriveAtPickup(at time.Time) error {
 	if t.State != DriverAssigned {
-		return errors.New("cannot start trip in current state")
+		return ErrNotEnRoute
+	}
+	if t.ArrivedAt.IsZero() {
+		t.ArrivedAt = at
+	}
+	return nil
+}
+
+// drivenBy checks that driverID is the driver assigned to the trip.
+func (t *Trip) drivenBy(driverID string) error {
+	if t.Driver == nil || t.Driver.ID != driverID {
+		return ErrNotTripDriver
 	}
-	t.State = InProgress
 	return nil
 }
 
-func (t *Trip) CompleteTrip(calculator PriceCalculator) error {
+func (t *Trip) StartTrip(at time.Time) error {
+	return t.transition(InProgress, at, func() {
+		t.StartedAt = at
+	})
+}
+
+// CompleteTrip ends the ride and prices it. A fare with lines in mixed
+// currencies is returned as an error and leaves the trip untouched.
+func (t *Trip) CompleteTrip(calculator PriceCalculator, at time.Time) error {
+	if !t.State.CanTransitionTo(Completed) {
+		return ErrInvalidTransition{From: t.State, To: Completed}
+	}
+	priced := t.clone()
+	priced.finish(at)
+	fare := calculator.CalculateFare(priced)
+	if err := fare.Err(); err != nil {
+		return fmt.Errorf("pricing trip %s: %w", t.ID, err)
+	}
+	return t.transition(Completed, at, func() {
+		t.finish(at)
+		t.Fare = fare.Total()
+		t.FareBreakdown = fare.Lines
+		t.BilledMiles = fare.Miles
+		t.DistanceSource = fare.DistanceSource
+	})
+}
+
+// finish stamps the drop-off time and closes a stop still being waited at.
+func (t *Trip) finish(at time.Time) {
+	t.CompletedAt = at
+	for i := range t.Waypoints {
+		if t.Waypoints[i].waiting() {
+			t.Waypoints[i].DepartedAt = at
+		}
+	}
+}
+
+// RecordLocation appends a GPS ping to the trip route. Pings are stored as
+// received; jitter and teleports are filtered out when the route is metered.
+func (t *Trip) RecordLocation(loc *Location, at time.Time) error {
 	if t.State != InProgress {
-		return errors.New("cannot complete trip in current state")
+		return ErrTripNotInProgress
 	}
-	t.Fare = calculator.CalculateFare(t)
-	t.State = Completed
+	t.Route = append(t.Route, LocationPing{Location: loc, Timestamp: at})
 	return nil
 }
 
-func (t *Trip) CancelTrip() error {
-	if t.State == Completed {
-		return errors.New("cannot cancel completed trip")
+// CancelTrip cancels the trip on behalf of by. The policy, if any, decides
+// the fee, which becomes the trip fare. A rider who wants out of a ride in
+// progress is dropped off and pays the fare so far, so their cancel is
+// refused.
+func (t *Trip) CancelTrip(by CancellationParty, reason string, policy CancellationPolicy, at time.Time) error {
+	if !by.valid() {
+		return ErrInvalidCancellationParty
+	}
+	if by == CancelledByRider && t.State == InProgress {
+		return ErrRideInProgress
+	}
+	return t.transition(Cancelled, at, func() {
+		cancellation := &Cancellation{By: by, Reason: reason, At: at}
+		if policy != nil {
+			fee, label := policy.CancellationFee(t, by, at)
+			if fee.IsPositive() {
+				cancellation.Fee = fee
+				t.Fare = fee
+				t.FareBreakdown = []FareLine{{Kind: FareFee, Label: label, Amount: fee}}
+			}
+		}
+		t.Cancellation = cancellation
+	})
+}
+
+// transition moves the trip to the given state at the given time if
+// tripTransitions allows it. apply mutates the trip for the new state
+// before the transition is recorded in History.
+func (t *Trip) transition(to TripState, at time.Time, apply func()) error {
+	if !t.State.CanTransitionTo(to) {
+		return ErrInvalidTransition{From: t.State, To: to}
+	}
+	if apply != nil {
+		apply()
 	}
-	t.State = Cancelled
+	t.History = append(t.History, StateTransition{From: t.State, To: to, At: at})
+	t.State = to
 	return nil
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+type TripEventType string
+
+const (
+	EventTripRequested      TripEventType = "TRIP_REQUESTED"
+	EventTripReleased       TripEventType = "TRIP_RELEASED"
+	EventTripOffered        TripEventType = "TRIP_OFFERED"
+	EventOfferWithdrawn     TripEventType = "OFFER_WITHDRAWN"
+	EventDriverAssigned     TripEventType = "DRIVER_ASSIGNED"
+	EventDriverArrived      TripEventType = "DRIVER_ARRIVED"
+	EventTripStarted        TripEventType = "TRIP_STARTED"
+	EventLocationRecorded   TripEventType = "LOCATION_RECORDED"
+	EventStopsUpdated       TripEventType = "STOPS_UPDATED"
+	EventDestinationChanged TripEventType = "DESTINATION_CHANGED"
+	EventTripCompleted      TripEventType = "TRIP_COMPLETED"
+	EventTripCancelled      TripEventType = "TRIP_CANCELLED"
+	EventFareComputed       TripEventType = "FARE_COMPUTED"
+	EventPaymentUpdated     TripEventType = "PAYMENT_UPDATED"
+	EventTripFlagged        TripEventType = "TRIP_FLAGGED"
+	EventTripReviewed       TripEventType = "TRIP_REVIEWED"
+	// EventTripUpdated carries the whole trip, for changes no other event
+	// describes, so replay always ends at the saved state.
+	EventTripUpdated TripEventType = "TRIP_UPDATED"
+)
+
+// TripEvent is one entry of the append-only trip log. Seq orders events
+// across all trips; Version is the trip version the event produced. Only
+// the fields of the event's type are set.
+type TripEvent struct {
+	Seq     int64         `json:"seq"`
+	TripID  string        `json:"trip_id"`
+	Version int           `json:"version"`
+	Type    TripEventType `json:"type"`
+	At      time.Time     `json:"at"`
+
+	Trip         *Trip              `json:"trip,omitempty"`
+	Transition   *StateTransition   `json:"transition,omitempty"`
+	Driver       *Driver            `json:"driver,omitempty"`
+	Pings        []LocationPing     `json:"pings,omitempty"`
+	Waypoints    []Waypoint         `json:"waypoints,omitempty"`
+	Destination  *DestinationChange `json:"destination,omitempty"`
+	DropoffZones []string           `json:"dropoff_zones,omitempty"`
+	Fare         *Money             `json:"fare,omitempty"`
+	FareLines    []FareLine         `json:"fare_lines,omitempty"`
+	BilledMiles  float64            `json:"billed_miles,omitempty"`
+	Source       string             `json:"distance_source,omitempty"` // where BilledMiles was measured
+	Cancellation *Cancellation      `json:"cancellation,omitempty"`
+	Payment      PaymentStatus      `json:"payment,omitempty"`
+	ChargeID     string             `json:"charge_id,omitempty"`
+	Review       *TripReview        `json:"review,omitempty"`
+}
+
+// transitionEventTypes names the event for a move into each state. Moving
+// back to Requested is either a scheduled trip being released or an offer
+// being withdrawn.
+var transitionEventTypes = map[TripState]TripEventType{
+	Offered:        EventTripOffered,
+	DriverAssigned: EventDriverAssigned,
+	InProgress:     EventTripStarted,
+	Completed:      EventTripCompleted,
+	Cancelled:      EventTripCancelled,
+}
+
+// tripEvents describes the change from before to after as events. before
+// is nil for a new trip. If the events don't replay to exactly after, a
+// TRIP_UPDATED event with the full trip is added. Changes that carry no
+// time of their own are stamped now.
+func tripEvents(before, after *Trip, now time.Time) []TripEvent {
+	if before == nil {
+		return []TripEvent{{TripID: after.ID, Version: after.Version, Type: EventTripRequested, At: after.RequestedAt, Trip: after.clone()}}
+	}
+
+	var events []TripEvent
+	add := func(event TripEvent) {
+		event.TripID = after.ID
+		event.Version = after.Version
+		events = append(events, event)
+	}
+
+	for i := len(before.History); i < len(after.History); i++ {
+		transition := after.History[i]
+		event := TripEvent{Type: transitionEventTypes[transition.To], At: transition.At, Transition: &transition}
+		switch transition.To {
+		case Requested:
+			event.Type = EventOfferWithdrawn
+			if transition.From == Scheduled {
+				event.Type = EventTripReleased
+			}
+		case DriverAssigned:
+			event.Driver = after.Driver
+		case Cancelled:
+			event.Cancellation = after.Cancellation
+		}
+		add(event)
+	}
+	if !after.ArrivedAt.Equal(before.ArrivedAt) {
+		add(TripEvent{Type: EventDriverArrived, At: after.ArrivedAt})
+	}
+	if len(after.Route) > len(before.Route) {
+		pings := after.Route[len(before.Route):]
+		add(TripEvent{Type: EventLocationRecorded, At: pings[len(pings)-1].Timestamp, Pings: pings})
+	}
+	if !sameWaypoints(before.Waypoints, after.Waypoints) {
+		add(TripEvent{Type: EventStopsUpdated, At: now, Waypoints: append([]Waypoint(nil), after.Waypoints...)})
+	}
+	for i := len(before.DestinationChanges); i < len(after.DestinationChanges); i++ {
+		change := after.DestinationChanges[i]
+		add(TripEvent{Type: EventDestinationChanged, At: change.At, Destination: &change, DropoffZones: after.DropoffZones})
+	}
+	if after.Fare != before.Fare || !fareLinesEqual(after.FareBreakdown, before.FareBreakdown) ||
+		after.BilledMiles != before.BilledMiles || after.DistanceSource != before.DistanceSource {
+		fare := after.Fare
+		add(TripEvent{Type: EventFareComputed, At: lastTransitionAt(after), Fare: &fare, FareLines: after.FareBreakdown,
+			BilledMiles: after.BilledMiles, Source: after.DistanceSource})
+	}
+	if after.Payment != before.Payment || after.ChargeID != before.ChargeID {
+		add(TripEvent{Type: EventPaymentUpdated, At: now, Payment: after.Payment, ChargeID: after.ChargeID})
+	}
+	if review := after.Review; review != nil && !sameReview(before.Review, review) {
+		event := TripEvent{Type: EventTripReviewed, At: review.ReviewedAt, Review: review.clone()}
+		if review.Status == ReviewHeld {
+			event.Type, event.At = EventTripFlagged, review.Flags[len(review.Flags)-1].At
+		}
+		add(event)
+	}
+
+	replayed := before.clone()
+	for _, event := range events {
+		replayed.apply(event)
+	}
+	replayed.Version = after.Version
+	if !sameTrip(replayed, after) {
+		add(TripEvent{Type: EventTripUpdated, At: now, Trip: after.clone()})
+	}
+	return events
+}
+
+// apply replays one event onto the trip.
+func (t *Trip) apply(event TripEvent) {
+	if event.Trip != nil {
+		*t = *event.Trip.clone()
+	}
+	if transition := event.Transition; transition != nil {
+		t.State = transition.To
+		t.History = append(t.History, *transition)
+		switch transition.To {
+		case DriverAssigned:
+			t.Driver = event.Driver
+		case InProgress:
+			t.StartedAt = transition.At
+		case Completed:
+			t.CompletedAt = transition.At
+		case Cancelled:
+			t.Cancellation = event.Cancellation
+		}
+	}
+
+	switch event.Type {
+	case EventDriverArrived:
+		t.ArrivedAt = event.At
+	case EventLocationRecorded:
+		t.Route = append(t.Route, event.Pings...)
+	case EventStopsUpdated:
+		t.Waypoints = append([]Waypoint(nil), event.Waypoints...)
+	case EventDestinationChanged:
+		t.DestinationChanges = append(t.DestinationChanges, *event.Destination)
+		t.DropoffLocation = event.Destination.To
+		t.DropoffZones = append([]string(nil), event.DropoffZones...)
+	case EventFareComputed:
+		t.Fare = *event.Fare
+		t.FareBreakdown = append([]FareLine(nil), event.FareLines...)
+		t.BilledMiles = event.BilledMiles
+		t.DistanceSource = event.Source
+	case EventPaymentUpdated:
+		t.Payment = event.Payment
+		t.ChargeID = event.ChargeID
+	case EventTripFlagged, EventTripReviewed:
+		t.Review = event.Review.clone()
+	}
+	t.Version = event.Version
+}
+
+func lastTransitionAt(t *Trip) time.Time {
+	if len(t.History) == 0 {
+		return t.RequestedAt
+	}
+	return t.History[len(t.History)-1].At
+}
+
+func fareLinesEqual(a, b []FareLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameWaypoints(a, b []Waypoint) bool {
+	return sameTrip(&Trip{Waypoints: a}, &Trip{Waypoints: b})
+}
+
+func sameReview(a, b *TripReview) bool {
+	return sameTrip(&Trip{Review: a}, &Trip{Review: b})
+}
+
+// sameTrip compares trips as they would be stored.
+func sameTrip(a, b *Trip) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrTripNotFound    = errors.New("trip not found")
+	ErrTripExists      = errors.New("trip already exists")
+	ErrVersionConflict = errors.New("trip was modified concurrently")
+)
+
+// TripRepository stores trips with optimistic locking: every save is a
+// compare-and-swap on Trip.Version.
+type TripRepository interface {
+	Create(trip *Trip) error
+	Get(id string) (*Trip, error)
+	// CompareAndSwap saves the trip only if the stored version still equals
+	// expectedVersion, and bumps trip.Version on success.
+	CompareAndSwap(trip *Trip, expectedVersion int) error
+}
+
+type TripRepositoryMemory struct {
+	mu    sync.Mutex
+	trips map[string]*Trip
+}
+
+func NewTripRepositoryMemory() *TripRepositoryMemory {
+	return &TripRepositoryMemory{
+		trips: make(map[string]*Trip),
+	}
+}
+
+func (r *TripRepositoryMemory) Create(trip *Trip) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.trips[trip.ID]; exists {
+		return ErrTripExists
+	}
+	r.trips[trip.ID] = trip.clone()
+	return nil
+}
+
+func (r *TripRepositoryMemory) Get(id string) (*Trip, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trip, exists := r.trips[id]
+	if !exists {
+		return nil, ErrTripNotFound
+	}
+	return trip.clone(), nil
+}
+
+func (r *TripRepositoryMemory) CompareAndSwap(trip *Trip, expectedVersion int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.trips[trip.ID]
+	if !exists {
+		return ErrTripNotFound
+	}
+	if stored.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	trip.Version = expectedVersion + 1
+	r.trips[trip.ID] = trip.clone()
+	return nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	eventLogFile     = "events.jsonl"
+	eventLogSnapshot = "snapshot.json"
+)
+
+// tripSnapshot is every trip as of event Seq. Offset is where the next
+// event starts in the log, so opening the log skips what the snapshot
+// already covers.
+type tripSnapshot struct {
+	Seq    int64            `json:"seq"`
+	Offset int64            `json:"offset"`
+	Trips  map[string]*Trip `json:"trips"`
+}
+
+// TripRepositoryEventLog is a TripRepository whose source of truth is an
+// append-only file of TripEvents. Current trips are kept in memory and
+// rebuilt on open from the latest snapshot plus the events after it. A
+// snapshot is written every snapshotEvery events. Events without a time of
+// their own, and the projection's "today", are read from clock.
+type TripRepositoryEventLog struct {
+	dir           string
+	snapshotEvery int
+	clock         Clock
+
+	mu            sync.Mutex
+	file          *os.File
+	offset        int64
+	seq           int64
+	sinceSnapshot int
+	trips         map[string]*Trip
+	driverDays    *DriverDayProjection
+}
+
+func OpenTripEventLog(dir string, snapshotEvery int, clock Clock, loc *time.Location) (*TripRepositoryEventLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	r := &TripRepositoryEventLog{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		clock:         clock,
+		trips:         make(map[string]*Trip),
+		driverDays:    NewDriverDayProjection(clock, loc),
+	}
+	if err := r.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, eventLogFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.replayFrom(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.file = file
+	return r, nil
+}
+
+func (r *TripRepositoryEventLog) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *TripRepositoryEventLog) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(r.dir, eventLogSnapshot))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot tripSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		// The log is never compacted, so it alone still rebuilds every trip.
+		log.Printf("event log: ignoring unreadable snapshot, replaying the whole log: %v", err)
+		return nil
+	}
+	r.seq, r.offset = snapshot.Seq, snapshot.Offset
+	for id, trip := range snapshot.Trips {
+		r.trips[id] = trip
+		r.driverDays.AddTrip(trip)
+	}
+	return nil
+}
+
+// replayFrom applies the events after the snapshot. A torn last line, left
+// by a crash mid-append, is cut off so the next append starts clean.
+func (r *TripRepositoryEventLog) replayFrom(file *os.File) error {
+	if _, err := file.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return file.Truncate(r.offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var event TripEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("trip event log at byte %d: %w", r.offset, err)
+		}
+		r.offset += int64(len(line))
+		r.applyEvent(event)
+	}
+}
+
+func (r *TripRepositoryEventLog) applyEvent(event TripEvent) {
+	trip, ok := r.trips[event.TripID]
+	if !ok {
+		trip = &Trip{}
+		r.trips[event.TripID] = trip
+	}
+	trip.apply(event)
+	r.driverDays.Apply(event)
+	r.seq = event.Seq
+	r.sinceSnapshot++
+}
+
+// append writes the events to the log, then applies them. Once the events
+// are written it succeeds, even if the snapshot that follows fails. Callers
+// hold mu.
+func (r *TripRepositoryEventLog) append(events []TripEvent) error {
+	var buf []byte
+	seq := r.seq
+	for i := range events {
+		seq++
+		events[i].Seq = seq
+		line, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := r.file.WriteAt(buf, r.offset); err != nil {
+		return err
+	}
+	if err := r.file.Sync(); err != nil {
+		return err
+	}
+	r.offset += int64(len(buf))
+	for _, event := range events {
+		r.applyEvent(event)
+	}
+
+	// The events are durable and applied, so a failed snapshot only means a
+	// longer replay on the next open. It is retried on the next append.
+	if r.snapshotEvery > 0 && r.sinceSnapshot >= r.snapshotEvery {
+		if err := r.snapshot(); err != nil {
+			log.Printf("event log: snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// snapshot writes all current trips next to the log, replacing the old
+// snapshot atomically. Callers hold mu.
+func (r *TripRepositoryEventLog) snapshot() error {
+	data, err := json.Marshal(tripSnapshot{Seq: r.seq, Offset: r.offset, Trips: r.trips})
+	if err != nil {
+		return err
+	}
+	if err := writeFileDurably(filepath.Join(r.dir, eventLogSnapshot), data); err != nil {
+		return err
+	}
+	r.sinceSnapshot = 0
+	return nil
+}
+
+// writeFileDurably replaces path with data through a synced temporary file,
+// then syncs the directory so the rename itself survives a crash.
+func writeFileDurably(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (r *TripRepositoryEventLog) Create(trip *Trip) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.trips[trip.ID]; exists {
+		return ErrTripExists
+	}
+	return r.append(tripEvents(nil, trip, r.clock.Now()))
+}
+
+func (r *TripRepositoryEventLog) Get(id string) (*Trip, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trip, exists := r.trips[id]
+	if !exists {
+		return nil, ErrTripNotFound
+	}
+	return trip.clone(), nil
+}
+
+func (r *TripRepositoryEventLog) CompareAndSwap(trip *Trip, expectedVersion int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.trips[trip.ID]
+	if !exists {
+		return ErrTripNotFound
+	}
+	if stored.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	next := trip.clone()
+	next.Version = expectedVersion + 1
+	if err := r.append(tripEvents(stored, next, r.clock.Now())); err != nil {
+		return err
+	}
+	trip.Version = next.Version
+	return nil
+}
+
+// Events reads the trip's events from the start of the log, oldest first.
+func (r *TripRepositoryEventLog) Events(tripID string) ([]TripEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reader := bufio.NewReader(io.NewSectionReader(r.file, 0, r.offset))
+	var events []TripEvent
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var event TripEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, err
+		}
+		if event.TripID == tripID {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		if _, exists := r.trips[tripID]; !exists {
+			return nil, ErrTripNotFound
+		}
+	}
+	return events, nil
+}
+
+// Replay rebuilds the trip from its events alone, ignoring snapshots.
+func (r *TripRepositoryEventLog) Replay(tripID string) (*Trip, error) {
+	events, err := r.Events(tripID)
+	if err != nil {
+		return nil, err
+	}
+	trip := &Trip{}
+	for _, event := range events {
+		trip.apply(event)
+	}
+	return trip, nil
+}
+
+// DriverTripsToday returns the driver's trips assigned so far today.
+func (r *TripRepositoryEventLog) DriverTripsToday(driverID string) []*Trip {
+	return r.DriverTripsOn(driverID, r.driverDays.Today())
+}
+
+// DriverTripsOn returns the driver's trips assigned on date (YYYY-MM-DD in
+// the projection's timezone), in assignment order.
+func (r *TripRepositoryEventLog) DriverTripsOn(driverID, date string) []*Trip {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var trips []*Trip
+	for _, id := range r.driverDays.TripIDs(driverID, date) {
+		if trip, ok := r.trips[id]; ok {
+			trips = append(trips, trip.clone())
+		}
+	}
+	return trips
+}
+
+// DriverDayProjection indexes trip IDs by driver and local calendar day of
+// assignment, so a driver's day is one map lookup rather than a log scan.
+type DriverDayProjection struct {
+	clock Clock
+	loc   *time.Location
+	days  map[string]map[string][]string // driver ID -> YYYY-MM-DD -> trip IDs
+}
+
+func NewDriverDayProjection(clock Clock, loc *time.Location) *DriverDayProjection {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &DriverDayProjection{clock: clock, loc: loc, days: make(map[string]map[string][]string)}
+}
+
+func (p *DriverDayProjection) Apply(event TripEvent) {
+	switch {
+	case event.Type == EventDriverAssigned && event.Driver != nil:
+		p.add(event.Driver.ID, event.At, event.TripID)
+	case event.Trip != nil:
+		p.AddTrip(event.Trip)
+	}
+}
+
+// AddTrip indexes a trip loaded whole, from a snapshot or TRIP_UPDATED.
+func (p *DriverDayProjection) AddTrip(trip *Trip) {
+	if trip.Driver == nil {
+		return
+	}
+	for _, transition := range trip.History {
+		if transition.To == DriverAssigned {
+			p.add(trip.Driver.ID, transition.At, trip.ID)
+		}
+	}
+}
+
+func (p *DriverDayProjection) add(driverID string, at time.Time, tripID string) {
+	day := at.In(p.loc).Format(time.DateOnly)
+	byDay, ok := p.days[driverID]
+	if !ok {
+		byDay = make(map[string][]string)
+		p.days[driverID] = byDay
+	}
+	for _, id := range byDay[day] {
+		if id == tripID {
+			return
+		}
+	}
+	byDay[day] = append(byDay[day], tripID)
+}
+
+func (p *DriverDayProjection) TripIDs(driverID, date string) []string {
+	return append([]string(nil), p.days[driverID][date]...)
+}
+
+// Today is the current date in the projection's timezone.
+func (p *DriverDayProjection) Today() string {
+	return p.clock.Now().In(p.loc).Format(time.DateOnly)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// TripRepositorySQLite keeps each trip as a JSON document next to its
+// version column, so the compare-and-swap is a single conditional UPDATE.
+// The caller opens db with a registered SQLite driver.
+type TripRepositorySQLite struct {
+	db *sql.DB
+}
+
+func NewTripRepositorySQLite(db *sql.DB) (*TripRepositorySQLite, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS trips (
+		id      TEXT PRIMARY KEY,
+		version INTEGER NOT NULL,
+		state   TEXT NOT NULL,
+		data    TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &TripRepositorySQLite{db: db}, nil
+}
+
+func (r *TripRepositorySQLite) Create(trip *Trip) error {
+	data, err := json.Marshal(trip)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.Exec(
+		"INSERT INTO trips (id, version, state, data) VALUES (?, ?, ?, ?) ON CONFLICT(id) DO NOTHING",
+		trip.ID, trip.Version, trip.State, string(data))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTripExists
+	}
+	return nil
+}
+
+func (r *TripRepositorySQLite) Get(id string) (*Trip, error) {
+	var data string
+	err := r.db.QueryRow("SELECT data FROM trips WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTripNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	trip := &Trip{}
+	if err := json.Unmarshal([]byte(data), trip); err != nil {
+		return nil, err
+	}
+	return trip, nil
+}
+
+func (r *TripRepositorySQLite) CompareAndSwap(trip *Trip, expectedVersion int) error {
+	next := trip.clone()
+	next.Version = expectedVersion + 1
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(
+		"UPDATE trips SET version = ?, state = ?, data = ? WHERE id = ? AND version = ?",
+		next.Version, next.State, string(data), trip.ID, expectedVersion)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := r.Get(trip.ID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+	trip.Version = next.Version
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// repositories returns a fresh instance of every TripRepository
+// implementation. The event log snapshots every few events so snapshots
+// are written while other saves are running.
+func repositories(t *testing.T) map[string]func() TripRepository {
+	t.Helper()
+	return map[string]func() TripRepository{
+		"memory": func() TripRepository { return NewTripRepositoryMemory() },
+		"event log": func() TripRepository {
+			log, err := OpenTripEventLog(t.TempDir(), 7, SystemClock{}, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { log.Close() })
+			return log
+		},
+		"sqlite": func() TripRepository {
+			db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "trips.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// SQLite allows one writer; a single connection queues them
+			// instead of failing with "database is locked".
+			db.SetMaxOpenConns(1)
+			t.Cleanup(func() { db.Close() })
+			repo, err := NewTripRepositorySQLite(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return repo
+		},
+	}
+}
+
+func inProgressTrip(id string) *Trip {
+	trip := NewTrip(id, &User{ID: "r1"}, testPickup, testDropoff, testNow)
+	trip.State = InProgress
+	trip.StartedAt = trip.RequestedAt
+	return trip
+}
+
+// Every writer retries on ErrVersionConflict until its ping is saved, so no
+// update may be lost and every successful save must bump the version once.
+func TestCompareAndSwapConcurrentWriters(t *testing.T) {
+	const writers, pings = 8, 10
+
+	for name, newRepo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo()
+			if err := repo.Create(inProgressTrip("t1")); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for p := 0; p < pings; p++ {
+						loc := &Location{Latitude: 37.7 + float64(w)/100, Longitude: -122.4 + float64(p)/100}
+						for {
+							trip, err := repo.Get("t1")
+							if err != nil {
+								t.Error(err)
+								return
+							}
+							if err := trip.RecordLocation(loc, time.Now()); err != nil {
+								t.Error(err)
+								return
+							}
+							err = repo.CompareAndSwap(trip, trip.Version)
+							if err == nil {
+								break
+							}
+							if !errors.Is(err, ErrVersionConflict) {
+								t.Error(err)
+								return
+							}
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			trip, err := repo.Get("t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trip.Route) != writers*pings {
+				t.Errorf("route has %d pings, want %d", len(trip.Route), writers*pings)
+			}
+			if trip.Version != writers*pings {
+				t.Errorf("version = %d, want %d", trip.Version, writers*pings)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwapRejectsStaleVersion(t *testing.T) {
+	for name, newRepo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo()
+			if err := repo.Create(inProgressTrip("t1")); err != nil {
+				t.Fatal(err)
+			}
+			first, _ := repo.Get("t1")
+			second, _ := repo.Get("t1")
+
+			first.RecordLocation(testPickup, time.Now())
+			if err := repo.CompareAndSwap(first, first.Version); err != nil {
+				t.Fatal(err)
+			}
+			second.RecordLocation(testDropoff, time.Now())
+			if err := repo.CompareAndSwap(second, second.Version); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("stale save: got %v, want ErrVersionConflict", err)
+			}
+
+			stored, _ := repo.Get("t1")
+			if len(stored.Route) != 1 || *stored.Route[0].Location != *testPickup {
+				t.Errorf("stored route %v, want only the first writer's ping", stored.Route)
+			}
+		})
+	}
+}
+
+func TestTripEventLogReopensConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenTripEventLog(dir, 7, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trips := NewTripService(repo, SystemClock{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("t%d", i)
+			if _, err := trips.RequestTrip(id, &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := trips.CancelTrip(id, CancelledByRider, "changed plans", nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	repo.Close()
+
+	reopened, err := OpenTripEventLog(dir, 7, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for i := 0; i < 10; i++ {
+		trip, err := reopened.Get(fmt.Sprintf("t%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trip.State != Cancelled || trip.Cancellation.Reason != "changed plans" {
+			t.Errorf("trip %s reopened as %s %+v", trip.ID, trip.State, trip.Cancellation)
+		}
+	}
+}
+
+// A snapshot that can't be written must not fail a save whose events are
+// already in the log, or the caller's version would fall behind.
+func TestTripEventLogSaveSurvivesFailedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenTripEventLog(dir, 1, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	// A directory where the snapshot's temp file goes makes every write fail.
+	if err := os.Mkdir(filepath.Join(dir, eventLogSnapshot+".tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	trip := inProgressTrip("t1")
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	trip.RecordLocation(testPickup, time.Now())
+	if err := repo.CompareAndSwap(trip, trip.Version); err != nil {
+		t.Fatalf("save with a failing snapshot: %v", err)
+	}
+	if trip.Version != 1 {
+		t.Errorf("version = %d, want 1", trip.Version)
+	}
+	trip.RecordLocation(testDropoff, time.Now())
+	if err := repo.CompareAndSwap(trip, trip.Version); err != nil {
+		t.Fatalf("next save: %v", err)
+	}
+}
+
+// A snapshot torn by a crash before it reached the disk must not keep the
+// log from opening; the log alone rebuilds the trips.
+func TestTripEventLogReplaysLogPastUnreadableSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenTripEventLog(dir, 1, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trip := inProgressTrip("t1")
+	if err := repo.Create(trip); err != nil {
+		t.Fatal(err)
+	}
+	trip.RecordLocation(testDropoff, testNow)
+	if err := repo.CompareAndSwap(trip, trip.Version); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+	if err := os.WriteFile(filepath.Join(dir, eventLogSnapshot), []byte(`{"seq": 2, "trips": {"t1"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenTripEventLog(dir, 1, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatalf("reopening past a torn snapshot: %v", err)
+	}
+	defer reopened.Close()
+	got, err := reopened.Get("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 1 || got.State != InProgress {
+		t.Errorf("reopened trip at version %d in %s, want version 1 in %s", got.Version, got.State, InProgress)
+	}
+}
+
+func TestRepositoryRoundTrip(t *testing.T) {
+	for name, newRepo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo()
+			trip := inProgressTrip("t1")
+			trip.Driver = &Driver{ID: "d1", Vehicle: Vehicle{Class: XL}}
+			trip.RecordLocation(testDropoff, trip.StartedAt.Add(time.Minute))
+			if err := repo.Create(trip); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Create(inProgressTrip("t1")); !errors.Is(err, ErrTripExists) {
+				t.Errorf("second create: got %v, want ErrTripExists", err)
+			}
+			if _, err := repo.Get("missing"); !errors.Is(err, ErrTripNotFound) {
+				t.Errorf("get missing: got %v, want ErrTripNotFound", err)
+			}
+			if err := repo.CompareAndSwap(inProgressTrip("missing"), 0); !errors.Is(err, ErrTripNotFound) {
+				t.Errorf("swap missing: got %v, want ErrTripNotFound", err)
+			}
+
+			got, err := repo.Get("t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.State != InProgress || got.Driver.ID != "d1" || got.Driver.Vehicle.Class != XL ||
+				len(got.Route) != 1 || !got.StartedAt.Equal(trip.StartedAt) {
+				t.Errorf("stored trip came back as %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// maxUpdateAttempts bounds how often a service call retries after losing a
+// compare-and-swap race before giving up with ErrVersionConflict.
+const maxUpdateAttempts = 5
+
+// ErrStaleTransition is returned by a before-transition hook when the trip
+// was changed using data that has moved on since, such as a promo discount
+// another trip just used up. The call re-runs its transition as it does
+// after a lost compare-and-swap.
+var ErrStaleTransition = errors.New("transition was computed from stale data")
+
+// TripService is the concurrency-safe entry point to the trip lifecycle.
+// Each call loads the latest version, applies one transition and saves it
+// with a compare-and-swap. The loser of a race re-reads the trip and re-runs
+// its transition against the winner's state, so two drivers accepting the
+// same trip, or a cancel racing a start, always resolve to exactly one
+// winner and an ErrInvalidTransition for the other.
+//
+// Transition hooks are registered here rather than on a Trip, because every
+// call works on a fresh copy loaded from the repository.
+type TripService struct {
+	repo        TripRepository
+	clock       Clock
+	geofence    *Geofence
+	observers   []func(trip *Trip)
+	beforeHooks []BeforeTransitionHook
+	afterHooks  []AfterTransitionHook
+	abortHooks  []AfterTransitionHook
+}
+
+// BeforeTransitionHook runs once a call has moved the trip to its new state
+// and before it is saved; an error vetoes the transition and nothing is saved.
+type BeforeTransitionHook func(t *Trip, transition StateTransition) error
+
+// AfterTransitionHook runs once the transition is saved, or, registered with
+// OnAbortedTransition, once it is thrown away.
+type AfterTransitionHook func(t *Trip, transition StateTransition)
+
+func NewTripService(repo TripRepository, clock Clock) *TripService {
+	return &TripService{repo: repo, clock: clock}
+}
+
+func (s *TripService) RequestTrip(id string, rider *User, pickup, dropoff *Location) (*Trip, error) {
+	return s.SubmitTrip(NewTrip(id, rider, pickup, dropoff, s.clock.Now()))
+}
+
+// UseGeofence makes new trips go through the geofence: out-of-area trips
+// are rejected and the rest tagged with their zones. Call it before serving.
+func (s *TripService) UseGeofence(geofence *Geofence) {
+	s.geofence = geofence
+}
+
+// OnTripSaved registers fn to be called with a copy of every trip created
+// or updated through the service, after the save succeeds. Register
+// observers before serving.
+func (s *TripService) OnTripSaved(fn func(trip *Trip)) {
+	s.observers = append(s.observers, fn)
+}
+
+// OnBeforeTransition registers a hook that can veto state changes made
+// through the service. Register hooks before serving.
+func (s *TripService) OnBeforeTransition(hook BeforeTransitionHook) {
+	s.beforeHooks = append(s.beforeHooks, hook)
+}
+
+// OnAfterTransition registers a hook for saved state changes, for
+// notifications and billing. Register hooks before serving.
+func (s *TripService) OnAfterTransition(hook AfterTransitionHook) {
+	s.afterHooks = append(s.afterHooks, hook)
+}
+
+// OnAbortedTransition registers a hook for transitions that were applied
+// but not saved, because a before hook vetoed them or the save lost a race.
+// It gets the same *Trip the before hooks saw, so it can release what they
+// reserved. Register hooks before serving.
+func (s *TripService) OnAbortedTransition(hook AfterTransitionHook) {
+	s.abortHooks = append(s.abortHooks, hook)
+}
+
+func (s *TripService) notify(trip *Trip) {
+	for _, observe := range s.observers {
+		observe(trip.clone())
+	}
+}
+
+// SubmitTrip stores a trip built by the caller, e.g. one with a vehicle
+// class or a locked-in surge quote.
+func (s *TripService) SubmitTrip(trip *Trip) (*Trip, error) {
+	if s.geofence != nil {
+		if err := s.geofence.Admit(trip); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.Create(trip); err != nil {
+		return nil, err
+	}
+	s.notify(trip)
+	return trip, nil
+}
+
+func (s *TripService) GetTrip(id string) (*Trip, error) {
+	return s.repo.Get(id)
+}
+
+func (s *TripService) ReleaseForMatching(id string) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.ReleaseForMatching(s.clock.Now())
+	})
+}
+
+func (s *TripService) OfferToDriver(id string) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.OfferToDriver(s.clock.Now())
+	})
+}
+
+func (s *TripService) WithdrawOffer(id string) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.WithdrawOffer(s.clock.Now())
+	})
+}
+
+// AssignReservedDriver assigns the trip to the driver holding its offer. If
+// the save loses a race, the driver's reservation is restored before
+// retrying.
+func (s *TripService) AssignReservedDriver(id, driverID string, dispatcher *Dispatcher) (*Trip, error) {
+	assigned := false
+	return s.updateWithRollback(id, func(trip *Trip) error {
+		_, err := dispatcher.AssignReserved(trip, driverID)
+		assigned = err == nil
+		return err
+	}, func() {
+		if assigned {
+			dispatcher.restoreReservation(driverID, id)
+			assigned = false
+		}
+	})
+}
+
+// AssignNearestDriver matches the trip through the dispatcher. If the save
+// loses a race, the driver is handed back to the dispatcher before retrying.
+func (s *TripService) AssignNearestDriver(id string, dispatcher *Dispatcher, k int, radiusMiles float64) (*Trip, error) {
+	var assigned *Driver
+	return s.updateWithRollback(id, func(trip *Trip) error {
+		driver, err := dispatcher.AssignNearest(trip, k, radiusMiles)
+		assigned = driver
+		return err
+	}, func() {
+		if assigned != nil {
+			dispatcher.ReleaseDriver(assigned.ID)
+			assigned = nil
+		}
+	})
+}
+
+// ArriveAtPickup records the assigned driver reaching the pickup, which
+// starts the no-show clock.
+func (s *TripService) ArriveAtPickup(id, driverID string) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		if err := trip.drivenBy(driverID); err != nil {
+			return err
+		}
+		return trip.ArriveAtPickup(s.clock.Now())
+	})
+}
+
+func (s *TripService) StartTrip(id string) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.StartTrip(s.clock.Now())
+	})
+}
+
+func (s *TripService) CompleteTrip(id string, calculator PriceCalculator) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.CompleteTrip(calculator, s.clock.Now())
+	})
+}
+
+func (s *TripService) CancelTrip(id string, by CancellationParty, reason string, policy CancellationPolicy) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		return trip.CancelTrip(by, reason, policy, s.clock.Now())
+	})
+}
+
+// CancelTripByDriver cancels on behalf of the driver assigned to the trip.
+func (s *TripService) CancelTripByDriver(id, driverID, reason string, policy CancellationPolicy) (*Trip, error) {
+	return s.update(id, func(trip *Trip) error {
+		if err := trip.drivenBy(driverID); err != nil {
+			return err
+		}
+		return trip.CancelTrip(CancelledByDriver, reason, policy, s.clock.Now())
+	})
+}
+
+// RecordLocation appends a GPS ping to an in-progress trip's route.
+func (s *TripService) RecordLocation(id string, loc *Location, at time.Time) (*Trip, error) {
+	return s.update(id, func(t *Trip) error {
+		return t.RecordLocation(loc, at)
+	})
+}
+
+// AddStop adds a stop before the dropoff. Stops outside the service area
+// are rejected.
+func (s *TripService) AddStop(id string, loc *Location, at time.Time) (*Trip, error) {
+	if s.geofence != nil {
+		if err := s.geofence.AdmitStop(loc); err != nil {
+			return nil, err
+		}
+	}
+	return s.update(id, func(t *Trip) error {
+		return t.AddStop(loc, at)
+	})
+}
+
+// ChangeDestination moves the dropoff, re-tagging its zones.
+func (s *TripService) ChangeDestination(id string, loc *Location, at time.Time) (*Trip, error) {
+	var zones []string
+	if s.geofence != nil {
+		if err := s.geofence.AdmitStop(loc); err != nil {
+			return nil, err
+		}
+		zones = s.geofence.zoneNamesAt(loc)
+	}
+	return s.update(id, func(t *Trip) error {
+		if err := t.ChangeDestination(loc, at); err != nil {
+			return err
+		}
+		t.DropoffZones = zones
+		return nil
+	})
+}
+
+func (s *TripService) ArriveAtStop(id string, at time.Time) (*Trip, error) {
+	return s.update(id, func(t *Trip) error {
+		return t.ArriveAtStop(at)
+	})
+}
+
+func (s *TripService) DepartStop(id string, at time.Time) (*Trip, error) {
+	return s.update(id, func(t *Trip) error {
+		return t.DepartStop(at)
+	})
+}
+
+// ResolveReview clears or rejects a trip held by the anomaly detector.
+func (s *TripService) ResolveReview(id string, status ReviewStatus, reviewer, note string, at time.Time) (*Trip, error) {
+	return s.update(id, func(t *Trip) error {
+		return t.ResolveReview(status, reviewer, note, at)
+	})
+}
+
+func (s *TripService) update(id string, apply func(trip *Trip) error) (*Trip, error) {
+	return s.updateWithRollback(id, apply, nil)
+}
+
+// updateWithRollback runs apply on a fresh copy of the trip and saves it.
+// rollback undoes side effects of apply outside the trip when the save loses
+// a race and the attempt is thrown away.
+func (s *TripService) updateWithRollback(id string, apply func(trip *Trip) error, rollback func()) (*Trip, error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		trip, err := s.repo.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		version := trip.Version
+		seen := len(trip.History)
+		if err := apply(trip); err != nil {
+			return nil, err
+		}
+		transitions := trip.History[seen:]
+		if err := s.beforeTransitions(trip, transitions); err != nil {
+			s.abortTransitions(trip, transitions)
+			if rollback != nil {
+				rollback()
+			}
+			if errors.Is(err, ErrStaleTransition) {
+				continue
+			}
+			return nil, err
+		}
+
+		err = s.repo.CompareAndSwap(trip, version)
+		if err == nil {
+			for _, transition := range transitions {
+				for _, hook := range s.afterHooks {
+					hook(trip, transition)
+				}
+			}
+			s.notify(trip)
+			return trip, nil
+		}
+		s.abortTransitions(trip, transitions)
+		if rollback != nil {
+			rollback()
+		}
+		if !errors.Is(err, ErrVersionConflict) {
+			return nil, err
+		}
+	}
+	return nil, ErrVersionConflict
+}
+
+func (s *TripService) beforeTransitions(trip *Trip, transitions []StateTransition) error {
+	for _, transition := range transitions {
+		for _, hook := range s.beforeHooks {
+			if err := hook(trip, transition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *TripService) abortTransitions(trip *Trip, transitions []StateTransition) {
+	for _, transition := range transitions {
+		for _, hook := range s.abortHooks {
+			hook(trip, transition)
+		}
+	}
+}
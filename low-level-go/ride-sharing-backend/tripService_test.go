@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testPickup  = &Location{Latitude: 37.7749, Longitude: -122.4194}
+	testDropoff = &Location{Latitude: 37.7849, Longitude: -122.4094}
+	// testNow is when every test clock starts.
+	testNow = time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+)
+
+func newTestService(t *testing.T) (*TripService, *VirtualClock) {
+	t.Helper()
+	clock := NewVirtualClock(testNow)
+	return NewTripService(NewTripRepositoryMemory(), clock), clock
+}
+
+func TestTripServiceTransitionHooks(t *testing.T) {
+	trips, clock := newTestService(t)
+	var after []StateTransition
+	trips.OnAfterTransition(func(trip *Trip, transition StateTransition) {
+		after = append(after, transition)
+	})
+	errNoCancels := errors.New("cancellations are closed")
+	trips.OnBeforeTransition(func(trip *Trip, transition StateTransition) error {
+		if transition.To == Cancelled {
+			return errNoCancels
+		}
+		return nil
+	})
+
+	if _, err := trips.RequestTrip("t1", &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := trips.OfferToDriver("t1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trips.CancelTrip("t1", CancelledByRider, "", nil); !errors.Is(err, errNoCancels) {
+		t.Fatalf("cancel: got %v, want the hook's veto", err)
+	}
+
+	trip, err := trips.GetTrip("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trip.State != Offered {
+		t.Errorf("vetoed cancel saved state %s", trip.State)
+	}
+	want := StateTransition{From: Requested, To: Offered, At: clock.Now()}
+	if len(after) != 1 || after[0] != want {
+		t.Errorf("after hooks saw %v, want [%v]", after, want)
+	}
+	if got := trip.History[len(trip.History)-1].At; !got.Equal(clock.Now()) {
+		t.Errorf("transition stamped %s, want the service clock's %s", got, clock.Now())
+	}
+}
+
+func TestTripServiceNoShowFee(t *testing.T) {
+	trips, clock := newTestService(t)
+	if _, err := trips.RequestTrip("t1", &User{ID: "r1"}, testPickup, testDropoff); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	dispatcher.GoOnline(&Driver{ID: "d1", Vehicle: Vehicle{Class: Economy}}, testPickup)
+	if _, err := trips.AssignNearestDriver("t1", dispatcher, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := trips.ArriveAtPickup("t1", "d2"); !errors.Is(err, ErrNotTripDriver) {
+		t.Errorf("arrive by another driver: got %v, want ErrNotTripDriver", err)
+	}
+	if _, err := trips.ArriveAtPickup("t1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trips.CancelTrip("t1", "PASSENGER", "", nil); !errors.Is(err, ErrInvalidCancellationParty) {
+		t.Errorf("unknown party: got %v, want ErrInvalidCancellationParty", err)
+	}
+
+	policy := DefaultCancellationPolicy(EUR)
+	clock.Advance(policy.NoShowWait)
+	trip, err := trips.CancelTrip("t1", CancelledByRider, "", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := MustParseMoney("7.50", EUR); trip.Cancellation.Fee != want || trip.Fare != want {
+		t.Errorf("fee = %s, fare = %s, want the no-show fee %s", trip.Cancellation.Fee, trip.Fare, want)
+	}
+}
+
+// Drivers keep heartbeating while trips are matched and saved to the event
+// log, which serializes each trip's driver. Run with -race.
+func TestTripServiceConcurrentMatching(t *testing.T) {
+	const drivers, riders = 10, 20
+
+	repo, err := OpenTripEventLog(t.TempDir(), 7, SystemClock{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	trips := NewTripService(repo, SystemClock{})
+	dispatcher := NewDispatcher(SystemClock{}, DefaultDriverPolicy())
+	for i := 0; i < drivers; i++ {
+		dispatcher.GoOnline(&Driver{ID: fmt.Sprintf("d%d", i), Vehicle: Vehicle{Class: Economy}}, testPickup)
+	}
+
+	stop := make(chan struct{})
+	var heartbeats sync.WaitGroup
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			loc := &Location{Latitude: testPickup.Latitude + float64(n%10)/10000, Longitude: testPickup.Longitude}
+			dispatcher.Heartbeat(fmt.Sprintf("d%d", n%drivers), loc)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	assigned := make(map[string]string) // driver ID -> trip ID
+	for i := 0; i < riders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("t%d", i)
+			if _, err := trips.RequestTrip(id, &User{ID: fmt.Sprintf("r%d", i)}, testPickup, testDropoff); err != nil {
+				t.Error(err)
+				return
+			}
+			trip, err := trips.AssignNearestDriver(id, dispatcher, drivers, 1)
+			if errors.Is(err, ErrNoDriverAvailable) {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := trips.RecordLocation(id, testPickup, time.Now()); !errors.Is(err, ErrTripNotInProgress) {
+				t.Errorf("ping before start: got %v, want ErrTripNotInProgress", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if other, taken := assigned[trip.Driver.ID]; taken {
+				t.Errorf("driver %s assigned to both %s and %s", trip.Driver.ID, other, id)
+			}
+			assigned[trip.Driver.ID] = id
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	heartbeats.Wait()
+
+	if len(assigned) != drivers {
+		t.Errorf("%d drivers assigned, want all %d", len(assigned), drivers)
+	}
+}
+
+// A cancel racing the driver completing the trip: exactly one wins.
+func TestTripServiceCancelRacesComplete(t *testing.T) {
+	for name, newRepo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			trips := NewTripService(newRepo(), SystemClock{})
+			for i := 0; i < 20; i++ {
+				trip := inProgressTrip(fmt.Sprintf("t%d", i))
+				if _, err := trips.SubmitTrip(trip); err != nil {
+					t.Fatal(err)
+				}
+
+				var wg sync.WaitGroup
+				var completeErr, cancelErr error
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_, completeErr = trips.CompleteTrip(trip.ID, StandardPricingCalculator{})
+				}()
+				go func() {
+					defer wg.Done()
+					_, cancelErr = trips.CancelTrip(trip.ID, CancelledByRider, "", nil)
+				}()
+				wg.Wait()
+
+				if (completeErr == nil) == (cancelErr == nil) {
+					t.Fatalf("trip %s: complete err %v, cancel err %v; want exactly one winner", trip.ID, completeErr, cancelErr)
+				}
+				saved, _ := trips.GetTrip(trip.ID)
+				if completeErr == nil && saved.State != Completed || cancelErr == nil && saved.State != Cancelled {
+					t.Errorf("trip %s saved as %s", trip.ID, saved.State)
+				}
+			}
+		})
+	}
+}
+
+func TestRiderCannotCancelRideInProgress(t *testing.T) {
+	trips, _ := newTestService(t)
+	if _, err := trips.SubmitTrip(inProgressTrip("t1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := trips.CancelTrip("t1", CancelledByRider, "changed my mind", DefaultCancellationPolicy(USD)); !errors.Is(err, ErrRideInProgress) {
+		t.Fatalf("rider cancel mid-ride: got %v, want ErrRideInProgress", err)
+	}
+	trip, err := trips.CancelTrip("t1", CancelledByDriver, "vehicle breakdown", DefaultCancellationPolicy(USD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trip.Fare.IsPositive() {
+		t.Errorf("driver cancel mid-ride charged the rider %s", trip.Fare)
+	}
+}
+
+func TestFreeCancelWindowFollowsServiceClock(t *testing.T) {
+	trips, clock := newTestService(t)
+	dispatcher := NewDispatcher(clock, DefaultDriverPolicy())
+	policy := DefaultCancellationPolicy(USD)
+	for _, tc := range []struct {
+		id    string
+		after time.Duration
+		want  Money
+	}{
+		{"early", time.Minute, Money{}},
+		{"late", 3 * time.Minute, policy.LateCancelFee},
+	} {
+		dispatcher.GoOnline(&Driver{ID: "d-" + tc.id, Vehicle: Vehicle{Class: Economy}}, testPickup)
+		trip, err := trips.RequestTrip(tc.id, &User{ID: "r1"}, testPickup, testDropoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !trip.RequestedAt.Equal(clock.Now()) {
+			t.Fatalf("requested at %s, want the service clock's %s", trip.RequestedAt, clock.Now())
+		}
+		if _, err := trips.AssignNearestDriver(tc.id, dispatcher, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(tc.after)
+		trip, err = trips.CancelTrip(tc.id, CancelledByRider, "", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trip.Fare != tc.want {
+			t.Errorf("%s cancel after %s: fee %s, want %s", tc.id, tc.after, trip.Fare, tc.want)
+		}
+	}
+}
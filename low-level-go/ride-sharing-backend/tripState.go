@@ -1,11 +1,47 @@
 package main
 
+import "fmt"
+
 type TripState string
 
 const (
+	Scheduled      TripState = "SCHEDULED"
 	Requested      TripState = "REQUESTED"
+	Offered        TripState = "OFFERED"
 	DriverAssigned TripState = "DRIVER_ASSIGNED"
 	InProgress     TripState = "IN_PROGRESS"
 	Completed      TripState = "COMPLETED"
 	Cancelled      TripState = "CANCELLED"
 )
+
+// tripTransitions lists every legal move of the trip state machine.
+// Completed and Cancelled are terminal.
+var tripTransitions = map[TripState][]TripState{
+	Scheduled:      {Requested, Cancelled},
+	Requested:      {Offered, DriverAssigned, Cancelled},
+	Offered:        {DriverAssigned, Requested, Cancelled},
+	DriverAssigned: {InProgress, Cancelled},
+	InProgress:     {Completed, Cancelled},
+}
+
+func (s TripState) CanTransitionTo(to TripState) bool {
+	for _, next := range tripTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s TripState) IsTerminal() bool {
+	return len(tripTransitions[s]) == 0
+}
+
+type ErrInvalidTransition struct {
+	From TripState
+	To   TripState
+}
+
+func (e ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("invalid trip transition from %s to %s", e.From, e.To)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	ErrTooManyStops  = errors.New("trip has the maximum number of stops")
+	ErrRouteLocked   = errors.New("stops and destination can't change once the trip has ended")
+	ErrNoStopAhead   = errors.New("trip has no stop ahead")
+	ErrNotAtStop     = errors.New("driver is not waiting at a stop")
+	ErrStillAtStop   = errors.New("driver has not left the current stop")
+	ErrStopsNotReady = errors.New("stops can only be reached while the trip is in progress")
+)
+
+const (
+	maxStops = 5
+	// freeStopWait is how long the driver waits at each stop before the
+	// wait is billed.
+	freeStopWait = 3 * time.Minute
+)
+
+// Waypoint is an intermediate stop on the way to the trip's DropoffLocation.
+// ArrivedAt and DepartedAt bound the wait that is billed.
+type Waypoint struct {
+	Location   *Location
+	AddedAt    time.Time
+	ArrivedAt  time.Time
+	DepartedAt time.Time
+}
+
+func (s Waypoint) waiting() bool {
+	return !s.ArrivedAt.IsZero() && s.DepartedAt.IsZero()
+}
+
+// DestinationChange records the rider moving the dropoff. Position is where
+// the car was at the time, from the last GPS ping or, without pings,
+// estimated along the leg being driven; nil before the ride.
+type DestinationChange struct {
+	From     *Location
+	To       *Location
+	At       time.Time
+	Position *Location
+}
+
+// AddStop appends a stop after the existing ones, before the dropoff.
+func (t *Trip) AddStop(loc *Location, at time.Time) error {
+	if t.State.IsTerminal() {
+		return ErrRouteLocked
+	}
+	if len(t.Waypoints) >= maxStops {
+		return ErrTooManyStops
+	}
+	t.Waypoints = append(t.Waypoints, Waypoint{Location: loc, AddedAt: at})
+	return nil
+}
+
+// ChangeDestination moves the dropoff and records when, and where the car
+// was, so pricing can follow the path actually driven.
+func (t *Trip) ChangeDestination(loc *Location, at time.Time) error {
+	if t.State.IsTerminal() {
+		return ErrRouteLocked
+	}
+	change := DestinationChange{From: t.DropoffLocation, To: loc, At: at}
+	if t.State == InProgress {
+		if change.Position = t.positionAt(at); change.Position == nil {
+			change.Position = t.estimatePosition(at)
+		}
+	}
+	t.DestinationChanges = append(t.DestinationChanges, change)
+	t.DropoffLocation = loc
+	return nil
+}
+
+// positionAt is the last pinged location at or before at.
+func (t *Trip) positionAt(at time.Time) *Location {
+	var position *Location
+	var latest time.Time
+	for _, ping := range t.Route {
+		if !ping.Timestamp.After(at) && !ping.Timestamp.Before(latest) {
+			position, latest = ping.Location, ping.Timestamp
+		}
+	}
+	return position
+}
+
+// estimatePosition guesses where the car is at when there are no pings: on
+// the straight line from the last point it left toward where it was headed,
+// as far as defaultSpeedMPH gets it in the time since.
+func (t *Trip) estimatePosition(at time.Time) *Location {
+	from, since := t.PickupLocation, t.StartedAt
+	for _, stop := range t.Waypoints {
+		switch {
+		case stop.ArrivedAt.IsZero() || stop.ArrivedAt.After(at):
+		case stop.DepartedAt.IsZero() || stop.DepartedAt.After(at):
+			return stop.Location
+		case stop.DepartedAt.After(since):
+			from, since = stop.Location, stop.DepartedAt
+		}
+	}
+	for _, change := range t.DestinationChanges {
+		if change.Position != nil && !change.At.After(at) && change.At.After(since) {
+			from, since = change.Position, change.At
+		}
+	}
+
+	_, to := t.NextDestination()
+	miles := HaversineDistance{}.DistanceMiles(from, to)
+	if miles == 0 {
+		return from
+	}
+	share := math.Min(1, at.Sub(since).Hours()*defaultSpeedMPH/miles)
+	if share <= 0 {
+		return from
+	}
+	return &Location{
+		Latitude:  from.Latitude + (to.Latitude-from.Latitude)*share,
+		Longitude: from.Longitude + (to.Longitude-from.Longitude)*share,
+	}
+}
+
+// ArriveAtStop starts the wait at the next stop.
+func (t *Trip) ArriveAtStop(at time.Time) error {
+	if t.State != InProgress {
+		return ErrStopsNotReady
+	}
+	for i := range t.Waypoints {
+		switch {
+		case t.Waypoints[i].waiting():
+			return ErrStillAtStop
+		case t.Waypoints[i].ArrivedAt.IsZero():
+			t.Waypoints[i].ArrivedAt = at
+			return nil
+		}
+	}
+	return ErrNoStopAhead
+}
+
+// DepartStop ends the wait at the current stop.
+func (t *Trip) DepartStop(at time.Time) error {
+	if t.State != InProgress {
+		return ErrStopsNotReady
+	}
+	for i := range t.Waypoints {
+		if t.Waypoints[i].waiting() {
+			t.Waypoints[i].DepartedAt = at
+			return nil
+		}
+	}
+	return ErrNotAtStop
+}
+
+// NextDestination is where the car is headed: the next stop not yet left,
+// or the dropoff.
+func (t *Trip) NextDestination() (target string, loc *Location) {
+	for _, stop := range t.Waypoints {
+		if stop.DepartedAt.IsZero() {
+			return "stop", stop.Location
+		}
+	}
+	return "dropoff", t.DropoffLocation
+}
+
+// Path is what the trip is priced along: the pickup, then the stops reached
+// and the positions where the destination was changed, in the order they
+// happened, then the stops still ahead and the dropoff. A finished trip,
+// including one being priced at completion, leaves out the stops it never
+// reached.
+func (t *Trip) Path() []*Location {
+	type reached struct {
+		loc *Location
+		at  time.Time
+	}
+	var passed []reached
+	var ahead []*Location
+	for _, stop := range t.Waypoints {
+		switch {
+		case !stop.ArrivedAt.IsZero():
+			passed = append(passed, reached{stop.Location, stop.ArrivedAt})
+		case !t.State.IsTerminal() && t.CompletedAt.IsZero():
+			ahead = append(ahead, stop.Location)
+		}
+	}
+	for _, change := range t.DestinationChanges {
+		if change.Position != nil {
+			passed = append(passed, reached{change.Position, change.At})
+		}
+	}
+	sort.SliceStable(passed, func(i, j int) bool { return passed[i].at.Before(passed[j].at) })
+
+	path := []*Location{t.PickupLocation}
+	for _, point := range passed {
+		path = append(path, point.loc)
+	}
+	path = append(path, ahead...)
+	return append(path, t.DropoffLocation)
+}
+
+// StopWait is the billable wait: the time at each stop beyond
+// freeStopWait. A stop still waiting counts until until.
+func (t *Trip) StopWait(until time.Time) time.Duration {
+	var billable time.Duration
+	for _, stop := range t.Waypoints {
+		if stop.ArrivedAt.IsZero() {
+			continue
+		}
+		departed := stop.DepartedAt
+		if departed.IsZero() {
+			departed = until
+		}
+		if wait := departed.Sub(stop.ArrivedAt) - freeStopWait; wait > 0 {
+			billable += wait
+		}
+	}
+	return billable
+}
+
+// legs sums distance and driving time along the trip's path.
+func legs(trip *Trip, distance DistanceProvider, eta ETAProvider) (miles, minutes float64, source string) {
+	path := trip.Path()
+	for i := 1; i < len(path); i++ {
+		miles += distance.DistanceMiles(path[i-1], path[i])
+		minutes += eta.DurationMinutes(path[i-1], path[i])
+		switch leg := distanceSourceOf(distance, path[i-1], path[i]); {
+		case source == "":
+			source = leg
+		case source != leg:
+			source = DistanceFromRoadGraph + " and " + DistanceStraightLine
+		}
+	}
+	return miles, minutes, source
+}
+
+// addStopWait bills the trip's wait at stops at the class's wait rate.
+func addStopWait(fare *FareBreakdown, rates ClassRates, trip *Trip) {
+	wait := trip.StopWait(trip.CompletedAt)
+	if wait <= 0 {
+		return
+	}
+	fare.Add(FareWait, fmt.Sprintf("Wait at stops (%.0f min)", wait.Minutes()), rates.waitPerMinute().Mul(wait.Minutes()))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDestinationChangeWithoutPingsBillsTheDrivenLeg(t *testing.T) {
+	trip := inProgressTrip("t1")
+	newDropoff := &Location{Latitude: 37.7649, Longitude: -122.4294}
+
+	// One minute at 30 mph is half a mile down the road to the old dropoff.
+	if err := trip.ChangeDestination(newDropoff, trip.StartedAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := trip.Path()
+	if len(path) != 3 || path[0] != testPickup || path[2] != newDropoff {
+		t.Fatalf("path = %v, want pickup, the position at the change, new dropoff", path)
+	}
+	distance := HaversineDistance{}
+	if driven := distance.DistanceMiles(testPickup, path[1]); math.Abs(driven-0.5) > 0.01 {
+		t.Errorf("estimated position is %.2f mi from pickup, want 0.5", driven)
+	}
+	toOld := distance.DistanceMiles(testPickup, path[1]) + distance.DistanceMiles(path[1], testDropoff)
+	if math.Abs(toOld-distance.DistanceMiles(testPickup, testDropoff)) > 0.01 {
+		t.Error("estimated position should lie on the way to the old dropoff")
+	}
+}
+
+func TestAddStopLimits(t *testing.T) {
+	trip := NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, testNow)
+	for i := 0; i < maxStops; i++ {
+		if err := trip.AddStop(&Location{Latitude: 37.77 + float64(i)/1000, Longitude: -122.42}, testNow); err != nil {
+			t.Fatalf("stop %d: %v", i+1, err)
+		}
+	}
+	if err := trip.AddStop(testDropoff, testNow); !errors.Is(err, ErrTooManyStops) {
+		t.Errorf("stop %d: got %v, want ErrTooManyStops", maxStops+1, err)
+	}
+
+	done := inProgressTrip("t2")
+	done.State = Completed
+	if err := done.AddStop(testDropoff, testNow); !errors.Is(err, ErrRouteLocked) {
+		t.Errorf("stop on a completed trip: got %v, want ErrRouteLocked", err)
+	}
+	if err := done.ChangeDestination(testDropoff, testNow); !errors.Is(err, ErrRouteLocked) {
+		t.Errorf("new destination on a completed trip: got %v, want ErrRouteLocked", err)
+	}
+}
+
+func TestStopsAreReachedInOrder(t *testing.T) {
+	first := &Location{Latitude: 37.7760, Longitude: -122.4180}
+	second := &Location{Latitude: 37.7800, Longitude: -122.4150}
+	trip := NewTrip("t1", &User{ID: "r1"}, testPickup, testDropoff, testNow)
+	trip.AddStop(first, testNow)
+	trip.AddStop(second, testNow)
+	if err := trip.ArriveAtStop(testNow); !errors.Is(err, ErrStopsNotReady) {
+		t.Errorf("arriving before the ride: got %v, want ErrStopsNotReady", err)
+	}
+
+	trip.State, trip.StartedAt = InProgress, testNow
+	at := testNow
+	step := func(name string, do func(time.Time) error, want error) {
+		t.Helper()
+		at = at.Add(time.Minute)
+		if err := do(at); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", name, err, want)
+		}
+	}
+	step("depart before arriving", trip.DepartStop, ErrNotAtStop)
+	step("arrive at the first stop", trip.ArriveAtStop, nil)
+	step("arrive while still there", trip.ArriveAtStop, ErrStillAtStop)
+	if _, next := trip.NextDestination(); next != first {
+		t.Errorf("waiting at the first stop, headed to %v", next)
+	}
+	step("leave the first stop", trip.DepartStop, nil)
+	if target, next := trip.NextDestination(); target != "stop" || next != second {
+		t.Errorf("after the first stop, headed to %s %v, want the second stop", target, next)
+	}
+	step("leave before reaching the second", trip.DepartStop, ErrNotAtStop)
+	step("arrive at the second stop", trip.ArriveAtStop, nil)
+	step("leave the second stop", trip.DepartStop, nil)
+	step("arrive with no stop left", trip.ArriveAtStop, ErrNoStopAhead)
+	if target, _ := trip.NextDestination(); target != "dropoff" {
+		t.Errorf("after every stop, headed to %s, want the dropoff", target)
+	}
+	if trip.Waypoints[0].ArrivedAt.After(trip.Waypoints[1].ArrivedAt) {
+		t.Error("stops reached out of order")
+	}
+}
+
+func TestStopWaitBilledPastFreeWait(t *testing.T) {
+	perMinute := MustParseMoney("0.25", USD)
+	tests := map[string]struct {
+		rates ClassRates
+		want  Money
+	}{
+		"wait rate set":      {ClassRates{PerMinute: perMinute, WaitPerMinute: MustParseMoney("0.40", USD)}, MustParseMoney("2.80", USD)},
+		"falls back to time": {ClassRates{PerMinute: perMinute}, MustParseMoney("1.75", USD)},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			trip := inProgressTrip("t1")
+			trip.AddStop(testDropoff, testNow)
+			trip.AddStop(testPickup, testNow)
+			// 8 minutes at the first stop and 2 at the second: 5 billable.
+			trip.Waypoints[0].ArrivedAt = testNow.Add(5 * time.Minute)
+			trip.Waypoints[0].DepartedAt = testNow.Add(13 * time.Minute)
+			trip.Waypoints[1].ArrivedAt = testNow.Add(20 * time.Minute)
+			trip.Waypoints[1].DepartedAt = testNow.Add(22 * time.Minute)
+			// Completing at a stop still waiting bills that wait too: 2 more.
+			trip.AddStop(testDropoff, testNow)
+			trip.Waypoints[2].ArrivedAt = testNow.Add(30 * time.Minute)
+			trip.CompletedAt = testNow.Add(35 * time.Minute)
+
+			if got := trip.StopWait(trip.CompletedAt); got != 7*time.Minute {
+				t.Fatalf("billable wait = %s, want 7m", got)
+			}
+			var fare FareBreakdown
+			addStopWait(&fare, tc.rates, trip)
+			if got := fare.Sum(FareWait); got != tc.want {
+				t.Errorf("wait charge = %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	var fare FareBreakdown
+	addStopWait(&fare, standardRates(), inProgressTrip("t2"))
+	if len(fare.Lines) != 0 {
+		t.Errorf("a trip without stops was billed %v", fare.Lines)
+	}
+}